@@ -7,18 +7,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"strings"
+	"time"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
 	dockerClient "github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/theupdateframework/notary"
 
-	"github.com/cnabio/signy/pkg/intoto"
+	"github.com/cnabio/signy/pkg/canonicaljson"
+	"github.com/cnabio/signy/pkg/docker"
+	"github.com/cnabio/signy/pkg/oci"
 	"github.com/cnabio/signy/pkg/tuf"
 )
 
@@ -31,15 +35,28 @@ func buildImageCommands() *cobra.Command {
 
 	cmd.AddCommand(buildImagePullCommand())
 	cmd.AddCommand(buildImagePushCommand())
+	cmd.AddCommand(buildImageSignCommand())
+	cmd.AddCommand(buildImageVerifyCommand())
 	return cmd
 }
 
 func buildImagePushCommand() *cobra.Command {
+	const pushDesc = `
+Pushes an image to a registry and gets its digest. After it's pushed, it pushes the digest to TUF.
+Registry credentials are read from the Docker configuration file and credential helpers, unless --registryUser
+and --registryCredentials, or the PUSH_REGISTRY_USER and PUSH_REGISTRY_CREDENTIALS environment variables, are set.
+
+Example:
+
+$ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 image push -i localhost:5000/image:v1
+
+In order to also push in-toto metadata to the TUF collection, use the --in-toto flag, together with --layout, --links and --layout-key.
+`
 	push := pushCmd{}
 	cmd := &cobra.Command{
 		Use:   "push [target reference]",
 		Short: "Pushes an image to a registry and trust data to TUF",
-		Long:  "Pushes an image to a registry and gets it's digest. After it's pushed, it pushes the digest to TUF alongside it's in-toto metadata",
+		Long:  pushDesc,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return push.run()
 		},
@@ -49,9 +66,13 @@ func buildImagePushCommand() *cobra.Command {
 	viper.AutomaticEnv()
 
 	cmd.Flags().StringVarP(&push.pushImage, "image", "i", "", "container image to push (must be built on your local system)")
+	cmd.Flags().BoolVarP(&push.intoto, "in-toto", "", false, "Adds in-toto metadata to TUF. If passed, the root layout, links directory, and root keys must be supplied")
 	cmd.Flags().StringVarP(&push.layout, "layout", "", "intoto/root.layout", "Path to the in-toto root layout file")
 	cmd.Flags().StringVarP(&push.linkDir, "links", "", "intoto/", "Path to the in-toto links directory")
-	cmd.Flags().StringVarP(&push.layoutKey, "layout-key", "", "intoto/root.pub", "Path to the in-toto root layout public keys")
+	cmd.Flags().StringSliceVarP(&push.layoutKeys, "layout-key", "", []string{"intoto/root.pub"}, "Path to the in-toto root layout public keys, one per layout owner")
+	cmd.Flags().IntVarP(&push.layoutThreshold, "layout-threshold", "", 0, "Number of layout owners that must sign the root layout (defaults to all of them)")
+	cmd.Flags().DurationVarP(&push.minValidity, "layout-min-validity", "", 0, "Refuses to push a layout that expires within this duration")
+	cmd.Flags().StringVarP(&push.intotoStore, "in-toto-store", "", "", `Stores the in-toto metadata out of band ("oci", "oci://<repository>", an HTTP blob store URL, or a local directory that verifiers pass to --in-toto-store)`)
 	cmd.Flags().StringVarP(&push.registryUser, "registryUser", "", viper.GetString("PUSH_REGISTRY_USER"), "docker registry user, also uses the PUSH_REGISTRY_USER environment variable")
 	cmd.Flags().StringVarP(&push.registryCredentials, "registryCredentials", "", viper.GetString("PUSH_REGISTRY_CREDENTIALS"), "docker registry credentials (api key or password), uses the PUSH_REGISTRY_CREDENTIALS environment variable")
 
@@ -59,19 +80,33 @@ func buildImagePushCommand() *cobra.Command {
 }
 
 func buildImagePullCommand() *cobra.Command {
+	const pullDesc = `
+Resolves the tag of an image to its trusted digest in TUF, pulls the image by that digest from a registry,
+and tags it locally once it is verified. If the verification fails, the pulled image is removed.
+
+In order to also verify the in-toto metadata from the TUF collection, use the --in-toto flag. As for "signy verify",
+the verification runs in a sandboxed container by default, or on the OS with --verify-on-os, and takes the same
+verification image, runtime, sandbox, report and layout expiry flags.
+
+Example:
 
-	pull := pullCmd{}
+$ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 image pull localhost:5000/image:v1 --in-toto
+`
+	pull := pullCmd{intotoVerification: newIntotoVerification()}
 	cmd := &cobra.Command{
 		Use:   "pull [target reference]",
 		Short: "Pulls an image from a registry and trust data from TUF and verifies it",
-		Long:  "Pulls an image from a registry. After it's pulled, it compares it's digest with what was stored in TUF and then verifies its in-toto metadata",
+		Long:  pullDesc,
+		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			pull.pullImage = args[0]
+			if err := pull.validate(cmd.Flags()); err != nil {
+				return err
+			}
 			return pull.run()
 		},
 	}
-
-	cmd.Flags().StringVarP(&pull.pullImage, "image", "i", "", "container image to pull")
-	//TODO: Add --verifyOnOS flag and verificationImage
+	pull.addFlags(cmd.Flags())
 
 	return cmd
 
@@ -79,15 +114,21 @@ func buildImagePullCommand() *cobra.Command {
 
 type pullCmd struct {
 	pullImage string
+
+	intotoVerification
 }
 
 type pushCmd struct {
 	pushImage string
 
+	intoto bool
 	layout string
 	// TODO: figure out a way to pass layout root key to TUF (not in the custom object)
-	layoutKey string
-	linkDir   string
+	layoutKeys      []string
+	layoutThreshold int
+	linkDir         string
+	intotoStore     string
+	minValidity     time.Duration
 
 	registryCredentials string
 	registryUser        string
@@ -105,55 +146,133 @@ func (v *pullCmd) run() error {
 		return fmt.Errorf("Couldn't initialize dockerClient")
 	}
 
-	//pull the image from the repository
-	log.Infof("Pulling image %v from registry", v.pullImage)
+	ref, err := reference.ParseNormalizedNamed(v.pullImage)
+	if err != nil {
+		return fmt.Errorf("invalid image reference %v: %v", v.pullImage, err)
+	}
+	if _, ok := ref.(reference.Digested); ok {
+		return fmt.Errorf("cannot pull %v by digest: the trusted digest is looked up by tag", v.pullImage)
+	}
+	ref = reference.TagNameOnly(ref)
 
-	_, err = cli.ImagePull(ctx, v.pullImage, types.ImagePullOptions{})
+	// The tag is resolved to its trusted digest before anything is pulled, and only that digest is pulled.
+	target, trustedSHA, err := tuf.GetTargetAndSHA(ref.String(), trustServer, tlscacert, trustDir, timeout)
 	if err != nil {
-		return fmt.Errorf("Couldnt pull image %v", err)
+		return err
+	}
+	trusted := digest.NewDigestFromEncoded(digest.SHA256, trustedSHA)
+	if err := trusted.Validate(); err != nil {
+		return fmt.Errorf("invalid trusted digest for %v: %v", v.pullImage, err)
+	}
+	var custom []byte
+	if target.Custom != nil {
+		custom = *target.Custom
+	}
+	m, err := oci.LoadImageMetadata(custom)
+	if err != nil {
+		return err
 	}
 
-	//there has to be a better way do do this, we inspect the image we just pulled, that image has a few digests (for example, if an image was tagged multiple times)
-	imageDigests, _, err := cli.ImageInspectWithRaw(ctx, v.pullImage)
+	// Images signed by the digest of their configuration are pulled by the digest of a manifest
+	// that was checked to reference the trusted configuration.
+	manifestDigest := trusted
+	if m.Kind == oci.ConfigDigest {
+		desc, config, err := oci.ResolveImageDigest(ctx, ref.String(), m.Kind)
+		if err != nil {
+			return err
+		}
+		if config != trusted {
+			return fmt.Errorf("image configuration digest %v doesn't match the trusted digest %v", config, trusted)
+		}
+		manifestDigest = desc.Digest
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(ref), manifestDigest)
 	if err != nil {
 		return err
 	}
 
-	pulledSHA := ""
-	for _, element := range imageDigests.RepoDigests {
+	// An image that was already present is left in place if the verification fails.
+	_, _, err = cli.ImageInspectWithRaw(ctx, pinned.String())
+	existed := err == nil
+	verified := false
+	defer func() {
+		if verified || existed {
+			return
+		}
+		log.Infof("Removing image %v, which failed verification", pinned)
+		if _, err := cli.ImageRemove(context.Background(), pinned.String(), types.ImageRemoveOptions{PruneChildren: true}); err != nil && !dockerClient.IsErrNotFound(err) {
+			log.Errorf("cannot remove image %v: %v", pinned, err)
+		}
+	}()
 
-		//remove the tag, since we have only digest now (image@sha256:)
-		parts := strings.Split(v.pullImage, ":")
+	//pull the image from the repository
+	log.Infof("Pulling image %v from registry", pinned)
 
-		if strings.Contains(element, parts[0]) {
-			//remove the image:@sha256, return only the actual sha
-			pulledSHA = strings.Split(element, ":")[1]
-		}
+	resp, err := cli.ImagePull(ctx, pinned.String(), types.ImagePullOptions{})
+	if err != nil {
+		return fmt.Errorf("Couldnt pull image %v", err)
+	}
+	pullDigest, err := docker.ReadPullOutput(resp)
+	resp.Close()
+	if err != nil {
+		return fmt.Errorf("cannot pull image %v: %v", pinned, err)
+	}
+	if pullDigest != "" && pullDigest != manifestDigest {
+		return fmt.Errorf("Pulled image digest doesn't match TUF SHA! Pulled SHA: %v doesn't match TUF SHA: %v ", pullDigest.Encoded(), manifestDigest.Encoded())
 	}
 
-	log.Infof("Successfully pulled image %v", v.pullImage)
-
-	//pull the data from notary
-	target, trustedSHA, err := tuf.GetTargetAndSHA(v.pullImage, trustServer, tlscacert, trustDir, timeout)
+	image, _, err := cli.ImageInspectWithRaw(ctx, pinned.String())
 	if err != nil {
 		return err
 	}
+	if !docker.HasRepoDigest(ref, image.RepoDigests, manifestDigest) {
+		return fmt.Errorf("cannot find digest %v in the pulled image %v", manifestDigest, pinned)
+	}
+	if m.Kind == oci.ConfigDigest && image.ID != trusted.String() {
+		return fmt.Errorf("pulled image ID %v doesn't match the trusted digest %v", image.ID, trusted)
+	}
+	if len(m.Manifests) > 0 {
+		platformManifest, err := signedPlatformManifest(ctx, ref, m, image)
+		if err != nil {
+			return err
+		}
+		log.Infof("Pulled platform manifest %v is listed in the signed image index", platformManifest)
+	} else if oci.IsIndex(m.MediaType) {
+		log.Warnf("The platform manifests of image index %v are not signed: the pulled platform manifest cannot be checked", trusted)
+	}
+	log.Infof("Pulled SHA matches TUF SHA: SHA256: %v", trustedSHA)
 
-	if pulledSHA == trustedSHA {
-		log.Infof("Pulled SHA matches TUF SHA: SHA256: %v matches %v", pulledSHA, trustedSHA)
-	} else {
-		return fmt.Errorf("Pulled image digest doesn't match TUF SHA! Pulled SHA: %v doesn't match TUF SHA: %v ", pulledSHA, trustedSHA)
+	if err := v.verify(target, []byte(v.pullImage)); err != nil {
+		return err
 	}
 
-	if target.Custom == nil {
-		return fmt.Errorf("Error: TUF server doesn't have the custom field filled with in-toto metadata")
+	if err := cli.ImageTag(ctx, pinned.String(), ref.String()); err != nil {
+		return fmt.Errorf("cannot tag image %v as %v: %v", pinned, ref, err)
 	}
+	verified = true
+	log.Infof("Successfully pulled image %v", v.pullImage)
+	return nil
+}
 
-	/*
-		TODO: Allow other verifications like `Signy verify` does, also fail better when RuleVerificationError happen
-			//return intoto.VerifyInContainer(target, []byte(v.pullImage), v.verificationImage, logLevel)
-	*/
-	return intoto.VerifyOnOS(target, []byte(v.pullImage))
+// signedPlatformManifest returns the digest of the signed platform manifest that references the configuration of
+// an image pulled from a signed image index. The daemon selects the platform manifest, so the signed platform manifests
+// for the platform of the image are resolved from the registry, by digest, in order to find the one it pulled.
+func signedPlatformManifest(ctx context.Context, ref reference.Named, m *oci.ImageMetadata, image types.ImageInspect) (digest.Digest, error) {
+	candidates := m.PlatformManifests(image.Os, image.Architecture)
+	for _, c := range candidates {
+		manifestRef, err := reference.WithDigest(reference.TrimNamed(ref), c.Digest)
+		if err != nil {
+			return "", err
+		}
+		_, config, err := oci.ResolveImageDigest(ctx, manifestRef.String(), oci.ConfigDigest)
+		if err != nil {
+			return "", err
+		}
+		if config.String() == image.ID {
+			return c.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("pulled image %v for %v/%v is not one of the %v platform manifests signed for this platform", image.ID, image.Os, image.Architecture, len(candidates))
 }
 
 func (v *pushCmd) run() error {
@@ -161,41 +280,36 @@ func (v *pushCmd) run() error {
 	if v.pushImage == "" {
 		return fmt.Errorf("Must specify an image for push")
 	}
-	if v.layout == "" || v.linkDir == "" || v.layoutKey == "" {
-		return fmt.Errorf("Required in-toto metadata not found")
-	}
 
-	if intoto.ValidateFromPath(v.layout) != nil {
-		return fmt.Errorf("validation for in-toto metadata failed")
+	ctx := context.Background()
+	var custom canonicaljson.RawMessage
+	if v.intoto {
+		// The in-toto metadata is validated before the image is pushed.
+		var err error
+		if custom, err = imageIntotoMetadata(ctx, v.pushImage, v.layout, v.linkDir, v.layoutKeys, v.layoutThreshold, v.minValidity, v.intotoStore); err != nil {
+			return err
+		}
 	}
 
 	//set up our docker client
 	cli, err := dockerClient.NewClientWithOpts(dockerClient.FromEnv, dockerClient.WithAPIVersionNegotiation())
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("cannot initialize Docker client: %v", err)
 	}
 
-	//setup auth to docker repo
-	authConfig := types.AuthConfig{
-		Username: v.registryUser,
-		Password: v.registryCredentials,
-	}
-	encodedJSON, err := json.Marshal(authConfig)
+	authStr, err := v.registryAuth(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("cannot get registry credentials: %v", err)
 	}
 
-	authStr := base64.URLEncoding.EncodeToString(encodedJSON)
-	ctx := context.Background()
-
 	log.Infof("Pushing image %v to registry", v.pushImage)
 
 	//push the image
 	resp, err := cli.ImagePush(ctx, v.pushImage, types.ImagePushOptions{RegistryAuth: authStr})
-	defer resp.Close()
 	if err != nil {
 		return fmt.Errorf("cannot push image to repository: %v", err)
 	}
+	defer resp.Close()
 
 	//for debugging, or else you cant see if wrong pw
 	//TODO: How to see this info all the time? if you consume it, it's no longer usable in the future
@@ -209,16 +323,13 @@ func (v *pushCmd) run() error {
 
 	log.Infof("Image successfully pushed: {tag, sha, size} %v", pushResult)
 
-	log.Infof("Adding In-Toto layout and links metadata to TUF")
-
-	//get the json message we'll be adding to the custom field
-	custom, err := intoto.GetMetadataRawMessage(v.layout, v.linkDir, v.layoutKey)
-	if err != nil {
-		return fmt.Errorf("cannot get metadata message: %v", err)
+	var cm *canonicaljson.RawMessage
+	if v.intoto {
+		cm = &custom
 	}
 
 	//Sign and publish and get a target back
-	target, err := tuf.SignAndPublishWithImagePushResult(trustDir, trustServer, v.pushImage, pushResult, tlscacert, "", timeout, &custom)
+	target, err := tuf.SignAndPublishWithImagePushResult(trustDir, trustServer, v.pushImage, pushResult, tlscacert, "", timeout, cm)
 	if err != nil {
 		return fmt.Errorf("cannot sign and publish trust data: %v", err)
 	}
@@ -228,6 +339,22 @@ func (v *pushCmd) run() error {
 	return nil
 }
 
+// registryAuth returns the encoded registry credentials of the flags or environment variables if any are set,
+// and otherwise the credentials of the Docker configuration file and credential helpers.
+func (v *pushCmd) registryAuth(ctx context.Context) (string, error) {
+	if v.registryUser == "" && v.registryCredentials == "" {
+		return docker.RegistryAuth(ctx, v.pushImage)
+	}
+	encodedJSON, err := json.Marshal(types.AuthConfig{
+		Username: v.registryUser,
+		Password: v.registryCredentials,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(encodedJSON), nil
+}
+
 //the docker daemon responds with a lot of messages. we're only interested in the response with the aux field, which contains the digest
 func parseDockerDaemonJSONMessages(r io.Reader) (types.PushResult, error) {
 	var result types.PushResult
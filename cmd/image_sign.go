@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/theupdateframework/notary"
+
+	"github.com/cnabio/signy/pkg/canonicaljson"
+	"github.com/cnabio/signy/pkg/intoto"
+	"github.com/cnabio/signy/pkg/oci"
+	"github.com/cnabio/signy/pkg/tuf"
+)
+
+// resolveImage resolves the manifest, or image index, of an image from the registry.
+var resolveImage = oci.Resolve
+
+type imageSignCmd struct {
+	ref       string
+	rootKey   string
+	local     string
+	refName   string
+	platforms bool
+
+	intoto          bool
+	layout          string
+	layoutKeys      []string
+	layoutThreshold int
+	linkDir         string
+	intotoStore     string
+	minValidity     time.Duration
+}
+
+func buildImageSignCommand() *cobra.Command {
+	const signDesc = `
+Signs an image that was already pushed to a registry. The digest and size of the image manifest are resolved
+from the registry, so no Docker daemon is needed, and pushed to the trust server under the tag of the reference.
+Registry credentials are read from the Docker configuration file and credential helpers.
+
+Example:
+
+$ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 image sign localhost:5000/image:v1
+INFO[0000] Resolved localhost:5000/image:v1 to sha256:d0a8... (528 bytes)
+INFO[0000] Pushed trust data for localhost:5000/image:v1: d0a8...
+
+If the reference also has a digest (<repository>:<tag>@sha256:<digest>), the tag must point to that digest in the registry.
+
+Images that were not pushed can be signed from an OCI image layout directory or tarball (as produced by buildkit or ko),
+or from a docker save tarball, with --local. The digest of the manifest, or image index, of OCI image layouts is signed.
+Since docker save archives do not contain the image manifest, the digest of their image configuration (the image ID) is signed instead.
+When the layout or archive contains several images, --ref-name selects one by its reference name annotation or tag.
+
+For multi-platform images, the digest of the image index is signed, and the digests of the platform manifests it lists
+are recorded in the custom trust data, unless --platform-manifests=false is passed. "signy image pull" then checks that
+the platform manifest pulled by the Docker daemon is one of them.
+
+$ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 image sign localhost:5000/image:v1 --local image.tar
+
+In order to also push in-toto metadata to the TUF collection, use the --in-toto flag, together with --layout, --links and --layout-key.
+`
+	sign := imageSignCmd{}
+	cmd := &cobra.Command{
+		Use:   "sign [target reference]",
+		Short: "Signs an image from a registry or an image layout without a Docker daemon",
+		Long:  signDesc,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sign.ref = args[0]
+			return sign.run()
+		},
+	}
+	cmd.Flags().StringVarP(&sign.rootKey, "root-key", "", "", "Root key to initialize the repository with")
+	cmd.Flags().StringVarP(&sign.local, "local", "", "", "Signs the image of an OCI image layout directory or tarball, or of a docker save tarball, instead of the registry")
+	cmd.Flags().StringVarP(&sign.refName, "ref-name", "", "", "Reference name annotation or tag of the image to sign, when the local layout contains several images")
+	cmd.Flags().BoolVarP(&sign.platforms, "platform-manifests", "", true, "Records the platform manifests listed by a signed image index, so that pulls can check the platform manifest they pulled")
+	cmd.Flags().BoolVarP(&sign.intoto, "in-toto", "", false, "Adds in-toto metadata to TUF. If passed, the root layout, links directory, and root keys must be supplied")
+	cmd.Flags().StringVarP(&sign.layout, "layout", "", "", "Path to the in-toto root layout file")
+	cmd.Flags().StringVarP(&sign.linkDir, "links", "", "", "Path to the in-toto links directory")
+	cmd.Flags().StringSliceVarP(&sign.layoutKeys, "layout-key", "", nil, "Path to the in-toto root layout public keys, one per layout owner")
+	cmd.Flags().IntVarP(&sign.layoutThreshold, "layout-threshold", "", 0, "Number of layout owners that must sign the root layout (defaults to all of them)")
+	cmd.Flags().DurationVarP(&sign.minValidity, "layout-min-validity", "", 0, "Refuses to sign with a layout that expires within this duration")
+	cmd.Flags().StringVarP(&sign.intotoStore, "in-toto-store", "", "", `Stores the in-toto metadata out of band ("oci", "oci://<repository>", an HTTP blob store URL, or a local directory that verifiers pass to --in-toto-store)`)
+
+	return cmd
+}
+
+func (s *imageSignCmd) run() error {
+	ref, err := reference.ParseNormalizedNamed(s.ref)
+	if err != nil {
+		return fmt.Errorf("invalid image reference %v: %v", s.ref, err)
+	}
+	tagged, ok := ref.(reference.NamedTagged)
+	if !ok {
+		return fmt.Errorf("image reference %v has no tag to sign", s.ref)
+	}
+	// The trust data is published for the tag, even if the reference also has a digest.
+	signRef, err := reference.WithTag(reference.TrimNamed(ref), tagged.Tag())
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var desc ocispec.Descriptor
+	var manifests []ocispec.Descriptor
+	kind := oci.ManifestDigest
+	if s.local != "" {
+		img, err := oci.LoadLocalImage(s.local, s.refName)
+		if err != nil {
+			return err
+		}
+		desc, kind, manifests = img.Descriptor, img.Kind, img.Manifests
+		if d, ok := ref.(reference.Digested); ok && d.Digest() != desc.Digest {
+			return fmt.Errorf("image of %v has digest %v, not %v", s.local, desc.Digest, d.Digest())
+		}
+		log.Infof("Computed %v digest of %v: %v (%v bytes)", kind, s.local, desc.Digest, desc.Size)
+	} else {
+		if desc, err = resolveTag(ctx, ref, signRef); err != nil {
+			return err
+		}
+		log.Infof("Resolved %v to %v (%v bytes)", s.ref, desc.Digest, desc.Size)
+		if oci.IsIndex(desc.MediaType) {
+			if manifests, err = oci.FetchIndexManifests(ctx, ref.String(), desc); err != nil {
+				return err
+			}
+		}
+	}
+	if !s.platforms {
+		manifests = nil
+	}
+	for _, m := range manifests {
+		log.Infof("Signing platform manifest %v for %v", m.Digest, platforms.Format(*m.Platform))
+	}
+
+	var custom canonicaljson.RawMessage
+	if s.intoto {
+		if custom, err = imageIntotoMetadata(ctx, signRef.String(), s.layout, s.linkDir, s.layoutKeys, s.layoutThreshold, s.minValidity, s.intotoStore); err != nil {
+			return err
+		}
+	}
+	custom, err = oci.ImageCustom(oci.ImageMetadata{MediaType: desc.MediaType, Kind: kind, Manifests: manifests}, custom)
+	if err != nil {
+		return err
+	}
+	cm := &custom
+
+	target, err := tuf.SignAndPublishWithDescriptor(trustDir, trustServer, signRef.String(), desc, tlscacert, s.rootKey, timeout, cm)
+	if err != nil {
+		return fmt.Errorf("cannot sign and publish trust data: %v", err)
+	}
+
+	log.Infof("Pushed trust data for %v: %v", signRef, hex.EncodeToString(target.Hashes[notary.SHA256]))
+	return nil
+}
+
+// resolveTag resolves the tag of an image reference, and checks that it points to the digest of the reference, if any.
+// The digest alone would be resolved regardless of the tag.
+func resolveTag(ctx context.Context, ref reference.Named, tagged reference.NamedTagged) (ocispec.Descriptor, error) {
+	desc, err := resolveImage(ctx, tagged.String())
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if d, ok := ref.(reference.Digested); ok && d.Digest() != desc.Digest {
+		return ocispec.Descriptor{}, fmt.Errorf("%v points to %v in the registry, not %v", tagged, desc.Digest, d.Digest())
+	}
+	return desc, nil
+}
+
+// imageIntotoMetadata validates the in-toto metadata of an image, and returns it as custom TUF metadata,
+// stored out of band if store is not empty.
+func imageIntotoMetadata(ctx context.Context, ref, layout, linkDir string, layoutKeys []string, threshold int, minValidity time.Duration, store string) (canonicaljson.RawMessage, error) {
+	if layout == "" || linkDir == "" || len(layoutKeys) == 0 {
+		return nil, fmt.Errorf("required in-toto metadata not found")
+	}
+	if err := intoto.ValidateFromPath(layout); err != nil {
+		return nil, fmt.Errorf("validation for in-toto metadata failed: %v", err)
+	}
+	if err := intoto.CheckLayoutExpiryFromPath(layout, minValidity); err != nil {
+		return nil, fmt.Errorf("refusing to sign with the in-toto layout: %v", err)
+	}
+
+	log.Infof("Adding In-Toto layout and links metadata to TUF")
+	custom, err := intoto.GetMetadataRawMessage(layout, linkDir, layoutKeys, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get metadata message: %v", err)
+	}
+	if store == "" {
+		return custom, nil
+	}
+	s, err := intoto.NewStore(store, ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create in-toto metadata store: %v", err)
+	}
+	return intoto.StoreExternal(ctx, s, custom)
+}
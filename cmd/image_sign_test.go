@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTag(t *testing.T) {
+	is := assert.New(t)
+
+	tagDigest := digest.FromString("v1")
+	otherDigest := digest.FromString("v2")
+
+	resolve := resolveImage
+	defer func() { resolveImage = resolve }()
+	var resolved []string
+	resolveImage = func(ctx context.Context, ref string) (ocispec.Descriptor, error) {
+		resolved = append(resolved, ref)
+		return ocispec.Descriptor{Digest: tagDigest, Size: 528}, nil
+	}
+	tagged, err := reference.WithTag(reference.TrimNamed(parseRef(t, "localhost:5000/image:v1")), "v1")
+	is.NoError(err)
+
+	desc, err := resolveTag(context.Background(), parseRef(t, "localhost:5000/image:v1@"+tagDigest.String()), tagged)
+	is.NoError(err)
+	is.Equal(tagDigest, desc.Digest)
+
+	// the digest exists in the repository, but the tag does not point to it.
+	_, err = resolveTag(context.Background(), parseRef(t, "localhost:5000/image:v1@"+otherDigest.String()), tagged)
+	is.EqualError(err, "localhost:5000/image:v1 points to "+tagDigest.String()+" in the registry, not "+otherDigest.String())
+
+	// the tag is always resolved, never the digest.
+	is.Equal([]string{"localhost:5000/image:v1", "localhost:5000/image:v1"}, resolved)
+}
+
+func parseRef(t *testing.T, ref string) reference.Named {
+	n, err := reference.ParseNormalizedNamed(ref)
+	assert.NoError(t, err)
+	return n
+}
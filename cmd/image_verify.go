@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/cnabio/signy/pkg/oci"
+	"github.com/cnabio/signy/pkg/tuf"
+)
+
+type imageVerifyCmd struct {
+	ref     string
+	local   string
+	refName string
+
+	intotoVerification
+}
+
+func buildImageVerifyCommand() *cobra.Command {
+	const verifyDesc = `
+Verifies that an image matches its trust data, without a Docker daemon and without pulling it.
+By default, the digest of the image is resolved from the registry. With --local, it is computed from an OCI image layout
+directory or tarball, or from a docker save tarball, and --ref-name selects the image when there are several.
+Images signed from a docker save tarball are verified by the digest of their image configuration.
+
+Example:
+
+$ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 image verify localhost:5000/image:v1 --local image.tar
+
+As for "signy image pull", --in-toto also verifies the in-toto metadata of the image.
+`
+	verify := imageVerifyCmd{intotoVerification: newIntotoVerification()}
+	cmd := &cobra.Command{
+		Use:   "verify [target reference]",
+		Short: "Verifies an image in a registry or an image layout against its trust data",
+		Long:  verifyDesc,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			verify.ref = args[0]
+			if err := verify.validate(cmd.Flags()); err != nil {
+				return err
+			}
+			return verify.run()
+		},
+	}
+	cmd.Flags().StringVarP(&verify.local, "local", "", "", "Verifies the image of an OCI image layout directory or tarball, or of a docker save tarball, instead of the registry")
+	cmd.Flags().StringVarP(&verify.refName, "ref-name", "", "", "Reference name annotation or tag of the image to verify, when the local layout contains several images")
+	verify.addFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (v *imageVerifyCmd) run() error {
+	ref, err := reference.ParseNormalizedNamed(v.ref)
+	if err != nil {
+		return fmt.Errorf("invalid image reference %v: %v", v.ref, err)
+	}
+	ref = reference.TagNameOnly(ref)
+
+	target, trustedSHA, err := tuf.GetTargetAndSHA(ref.String(), trustServer, tlscacert, trustDir, timeout)
+	if err != nil {
+		return err
+	}
+	trusted := digest.NewDigestFromEncoded(digest.SHA256, trustedSHA)
+	var custom []byte
+	if target.Custom != nil {
+		custom = *target.Custom
+	}
+	m, err := oci.LoadImageMetadata(custom)
+	if err != nil {
+		return err
+	}
+
+	var computed digest.Digest
+	if v.local != "" {
+		img, err := oci.LoadLocalImage(v.local, v.refName)
+		if err != nil {
+			return err
+		}
+		if img.Kind != m.Kind {
+			return fmt.Errorf("the %v digest of %v is signed, but %v has a %v digest", m.Kind, v.ref, v.local, img.Kind)
+		}
+		computed = img.Descriptor.Digest
+	} else {
+		if _, computed, err = oci.ResolveImageDigest(context.Background(), ref.String(), m.Kind); err != nil {
+			return err
+		}
+	}
+
+	if computed != trusted {
+		return fmt.Errorf("%v digest %v of the image does not match the trusted digest %v", m.Kind, computed, trusted)
+	}
+	log.Infof("The %v digest of the image matches the trusted digest: %v", m.Kind, trusted)
+
+	return v.verify(target, []byte(v.ref))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cnabio/signy/pkg/intoto"
+	"github.com/cnabio/signy/pkg/tuf"
+)
+
+type inspectCmd struct {
+	ref         string
+	intotoStore string
+}
+
+func newInspectCmd() *cobra.Command {
+	const inspectDesc = `
+Shows the trust data for a target: its digest, the role that signed it, the expiry of the TUF metadata
+of the trusted collection, and the expiry of the in-toto root layout, if any.
+
+Example:
+$ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 inspect localhost:5000/thin-intoto:v2
+
+Target:         localhost:5000/thin-intoto:v2
+SHA256:         c7e92bd51f059d60b15ad456edf194648997d739f60799b37e08edafd88a81b5
+Size:           1157
+Role:           targets
+
+Role            Expires
+root            2030-05-12T09:53:02Z (in 3485 days)
+snapshot        2023-05-12T09:53:02Z (in 928 days)
+targets         2023-05-12T09:53:02Z (in 928 days)
+timestamp       2020-10-26T09:53:02Z (in 13h59m)
+in-toto layout  2020-11-11T09:53:02Z (in 16 days)
+`
+	inspect := inspectCmd{}
+	cmd := &cobra.Command{
+		Use:   "inspect [target reference]",
+		Short: "Shows the trust data and the metadata expiry for a target",
+		Long:  inspectDesc,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inspect.ref = args[0]
+			return inspect.run()
+		},
+	}
+	cmd.Flags().StringVarP(&inspect.intotoStore, "in-toto-store", "", "", "Local directory of the in-toto metadata, if it was signed with --in-toto-store <directory>")
+
+	return cmd
+}
+
+func (i *inspectCmd) run() error {
+	target, trustedSHA, err := tuf.GetTargetAndSHA(i.ref, trustServer, tlscacert, trustDir, timeout)
+	if err != nil {
+		return err
+	}
+	expiries, err := tuf.GetRoleExpiries(i.ref, trustDir)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Target:\t%v\n", i.ref)
+	fmt.Fprintf(w, "SHA256:\t%v\n", trustedSHA)
+	fmt.Fprintf(w, "Size:\t%v\n", target.Length)
+	fmt.Fprintf(w, "Role:\t%v\n", target.Role)
+	w.Flush()
+	fmt.Println()
+
+	now := time.Now()
+	fmt.Fprintf(w, "Role\tExpires\n")
+	for _, e := range expiries {
+		fmt.Fprintf(w, "%v\t%v (%v)\n", e.Role, e.Expires.UTC().Format(time.RFC3339), expiresIn(e.Expires, now))
+	}
+	if target.Custom != nil {
+		ctx := context.Background()
+		if i.intotoStore != "" {
+			ctx = intoto.WithLocalStore(ctx, i.intotoStore)
+		}
+		expires, ok, err := intoto.MetadataLayoutExpiry(ctx, *target.Custom)
+		if err != nil {
+			return fmt.Errorf("cannot get in-toto layout expiry: %v", err)
+		}
+		if ok {
+			fmt.Fprintf(w, "in-toto layout\t%v (%v)\n", expires.UTC().Format(time.RFC3339), expiresIn(expires, now))
+		}
+	}
+	return w.Flush()
+}
+
+func expiresIn(expires, now time.Time) string {
+	d := expires.Sub(now)
+	switch {
+	case d <= 0:
+		return "EXPIRED"
+	case d < 24*time.Hour:
+		return fmt.Sprintf("in %v", d.Truncate(time.Minute))
+	default:
+		return fmt.Sprintf("in %d days", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cnabio/signy/pkg/intoto"
+)
+
+func buildInTotoCommands() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "intoto",
+		Short: "in-toto commands",
+		Long:  "Commands for producing in-toto supply chain metadata.",
+	}
+
+	cmd.AddCommand(buildInTotoRunCommand())
+	cmd.AddCommand(buildInTotoRecordCommands())
+	cmd.AddCommand(buildInTotoLayoutCommands())
+	return cmd
+}
+
+type stepCmd struct {
+	step      string
+	key       string
+	keyScheme string
+	linkDir   string
+	materials []string
+	products  []string
+	exclude   []string
+}
+
+func (s *stepCmd) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&s.step, "step", "", "", "Name of the supply chain step, as found in the layout")
+	cmd.Flags().StringVarP(&s.key, "key", "", "", "Path to the functionary private key used to sign the link")
+	cmd.Flags().StringVarP(&s.keyScheme, "key-scheme", "", intoto.DefaultKeyScheme, "Signature scheme of the functionary key")
+	cmd.Flags().StringVarP(&s.linkDir, "links", "", ".", "Directory where the link is written")
+	cmd.Flags().StringSliceVarP(&s.exclude, "exclude", "", nil, "gitignore style patterns of artifacts not to record")
+	cmd.MarkFlagRequired("step")
+	cmd.MarkFlagRequired("key")
+}
+
+func buildInTotoRunCommand() *cobra.Command {
+	const runDesc = `
+Runs the command of a supply chain step, records the hashes of its materials before, and of its products after,
+and writes the link signed with the functionary key, in the format expected by "signy sign --in-toto".
+
+Example:
+
+$ signy intoto run --step package --key bob --materials foo.py --products foo.tar.gz -- tar zcvf foo.tar.gz foo.py
+INFO[0000] Running step package: [tar zcvf foo.tar.gz foo.py]
+INFO[0000] Wrote link package.2f89b927.link
+`
+	run := stepCmd{}
+	cmd := &cobra.Command{
+		Use:   "run --step NAME --key KEY [--materials PATHS] [--products PATHS] -- COMMAND",
+		Short: "Runs a supply chain step and records its signed link",
+		Long:  runDesc,
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run.run(args)
+		},
+	}
+	run.addFlags(cmd)
+	cmd.Flags().StringSliceVarP(&run.materials, "materials", "", nil, "Paths to the materials of the step")
+	cmd.Flags().StringSliceVarP(&run.products, "products", "", nil, "Paths to the products of the step")
+
+	return cmd
+}
+
+func buildInTotoRecordCommands() *cobra.Command {
+	const recordDesc = `
+Records the materials and products of a supply chain step whose commands are not run by signy.
+"record start" records the materials and writes an unfinished link, "record stop" records the products and writes the signed link.
+
+Example:
+
+$ signy intoto record start --step update-version --key alice --materials demo-project
+$ vi demo-project/foo.py
+$ signy intoto record stop --step update-version --key alice --products demo-project
+INFO[0000] Wrote link update-version.776a00e2.link
+`
+	cmd := &cobra.Command{
+		Use:   "record",
+		Short: "Records the materials and products of a supply chain step",
+		Long:  recordDesc,
+	}
+
+	start := stepCmd{}
+	startCmd := &cobra.Command{
+		Use:   "start --step NAME --key KEY [--materials PATHS]",
+		Short: "Records the materials of a supply chain step",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return start.recordStart()
+		},
+	}
+	start.addFlags(startCmd)
+	startCmd.Flags().StringSliceVarP(&start.materials, "materials", "", nil, "Paths to the materials of the step")
+
+	stop := stepCmd{}
+	stopCmd := &cobra.Command{
+		Use:   "stop --step NAME --key KEY [--products PATHS]",
+		Short: "Records the products of a supply chain step and writes its signed link",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return stop.recordStop()
+		},
+	}
+	stop.addFlags(stopCmd)
+	stopCmd.Flags().StringSliceVarP(&stop.products, "products", "", nil, "Paths to the products of the step")
+
+	cmd.AddCommand(startCmd, stopCmd)
+	return cmd
+}
+
+func (s *stepCmd) run(cmdArgs []string) error {
+	key, err := intoto.LoadKey(s.key, s.keyScheme)
+	if err != nil {
+		return err
+	}
+	_, err = intoto.RunStep(s.step, key, s.materials, s.products, s.exclude, cmdArgs, s.linkDir)
+	return err
+}
+
+func (s *stepCmd) recordStart() error {
+	key, err := intoto.LoadKey(s.key, s.keyScheme)
+	if err != nil {
+		return err
+	}
+	_, err = intoto.RecordStart(s.step, key, s.materials, s.exclude, s.linkDir)
+	return err
+}
+
+func (s *stepCmd) recordStop() error {
+	key, err := intoto.LoadKey(s.key, s.keyScheme)
+	if err != nil {
+		return err
+	}
+	_, err = intoto.RecordStop(s.step, key, s.products, s.exclude, s.linkDir)
+	return err
+}
+
+type layoutCmd struct {
+	spec      string
+	layout    string
+	key       string
+	keyScheme string
+	output    string
+}
+
+func buildInTotoLayoutCommands() *cobra.Command {
+	const layoutDesc = `
+Creates and signs in-toto root layouts.
+
+Example: creates a root layout from a declarative spec, signed by a first project owner, then adds the signature of a second owner
+
+$ cat layout.yaml
+expires: 8760h
+keys:
+  alice: alice.pub
+  bob: bob.pub
+steps:
+- name: package
+  functionaries: [bob]
+  expected_command: [tar, zcvf, foo.tar.gz, foo.py]
+  expected_materials:
+  - DISALLOW *
+  expected_products:
+  - CREATE foo.tar.gz
+inspections:
+- name: untar
+  run: [tar, xfz, foo.tar.gz]
+  expected_materials:
+  - MATCH foo.tar.gz WITH PRODUCTS FROM package
+
+$ signy intoto layout create --spec layout.yaml --key owner.pem -o root.layout
+$ signy intoto layout sign --key second-owner.pem root.layout
+`
+	cmd := &cobra.Command{
+		Use:   "layout",
+		Short: "Creates and signs in-toto root layouts",
+		Long:  layoutDesc,
+	}
+
+	create := layoutCmd{}
+	createCmd := &cobra.Command{
+		Use:   "create --spec SPEC --key KEY -o LAYOUT",
+		Short: "Creates a signed root layout from a declarative spec",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return create.create()
+		},
+	}
+	createCmd.Flags().StringVarP(&create.spec, "spec", "", "", "Path to the YAML layout spec")
+	createCmd.Flags().StringVarP(&create.key, "key", "", "", "Path to the layout owner private key")
+	createCmd.Flags().StringVarP(&create.keyScheme, "key-scheme", "", intoto.DefaultKeyScheme, "Signature scheme of the layout owner key")
+	createCmd.Flags().StringVarP(&create.output, "output", "o", "root.layout", "Path where the signed layout is written")
+	createCmd.MarkFlagRequired("spec")
+	createCmd.MarkFlagRequired("key")
+
+	sign := layoutCmd{}
+	signCmd := &cobra.Command{
+		Use:   "sign --key KEY [layout]",
+		Short: "Adds the signature of a layout owner to a root layout",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sign.layout = args[0]
+			return sign.sign()
+		},
+	}
+	signCmd.Flags().StringVarP(&sign.key, "key", "", "", "Path to the layout owner private key")
+	signCmd.Flags().StringVarP(&sign.keyScheme, "key-scheme", "", intoto.DefaultKeyScheme, "Signature scheme of the layout owner key")
+	signCmd.Flags().StringVarP(&sign.output, "output", "o", "", "Path where the signed layout is written (defaults to the layout itself)")
+	signCmd.MarkFlagRequired("key")
+
+	cmd.AddCommand(createCmd, signCmd)
+	return cmd
+}
+
+func (l *layoutCmd) create() error {
+	key, err := intoto.LoadKey(l.key, l.keyScheme)
+	if err != nil {
+		return err
+	}
+	return intoto.CreateLayout(l.spec, key, l.output)
+}
+
+func (l *layoutCmd) sign() error {
+	key, err := intoto.LoadKey(l.key, l.keyScheme)
+	if err != nil {
+		return err
+	}
+	if l.output == "" {
+		l.output = l.layout
+	}
+	return intoto.SignLayout(l.layout, key, l.output)
+}
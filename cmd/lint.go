@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/spf13/cobra"
+
+	"github.com/cnabio/signy/pkg/cnab"
+)
+
+type lintCmd struct {
+	file string
+}
+
+func newLintCmd() *cobra.Command {
+	const lintDesc = `
+Checks that a CNAB bundle can be safely signed. Every invocation image and component image must be pinned
+by a contentDigest, and must not use the mutable latest tag, so that the signed bundle cannot resolve to
+different images later. The bundle must also have a valid schema version, and a semantic version.
+
+"signy sign --strict" runs the same checks before signing.
+
+Example:
+
+$ signy lint bundle.json
+invocationImages[0]: image cnab/helloworld:0.1.1 has no contentDigest: set it to the digest of the image manifest, for example from "docker inspect --format '{{index .RepoDigests 0}}' cnab/helloworld:0.1.1"
+Error: found 1 problem(s) in bundle.json
+`
+	lint := lintCmd{}
+	cmd := &cobra.Command{
+		Use:   "lint [bundle file]",
+		Short: "Checks that a bundle pins its images before signing it",
+		Long:  lintDesc,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			lint.file = args[0]
+			return lint.run()
+		},
+	}
+
+	return cmd
+}
+
+func (l *lintCmd) run() error {
+	b, err := ioutil.ReadFile(l.file)
+	if err != nil {
+		return fmt.Errorf("cannot read bundle file: %v", err)
+	}
+	problems, err := lintBundle(b)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %v problem(s) in %v", len(problems), l.file)
+	}
+	fmt.Printf("%v can be signed\n", l.file)
+	return nil
+}
+
+// lintBundle decodes a bundle.json, and returns the problems that prevent it from being signed safely.
+func lintBundle(buf []byte) ([]cnab.Problem, error) {
+	var b bundle.Bundle
+	if err := json.Unmarshal(buf, &b); err != nil {
+		return nil, fmt.Errorf("cannot decode bundle: %v", err)
+	}
+	return cnab.Lint(&b), nil
+}
@@ -59,7 +59,10 @@ func init() {
 		newListCmd(),
 		newSignCmd(),
 		newVerifyCmd(),
+		newInspectCmd(),
+		newLintCmd(),
 		buildImageCommands(),
+		buildInTotoCommands(),
 		versionCmd,
 	)
 
@@ -1,29 +1,56 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"time"
 
+	"github.com/docker/cnab-to-oci/relocation"
+	"github.com/in-toto/in-toto-golang/in_toto"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/theupdateframework/notary/client"
 
 	"github.com/cnabio/signy/pkg/canonicaljson"
 	"github.com/cnabio/signy/pkg/cnab"
 	"github.com/cnabio/signy/pkg/intoto"
+	"github.com/cnabio/signy/pkg/oci"
 	"github.com/cnabio/signy/pkg/tuf"
 )
 
 type signCmd struct {
-	ref     string
-	thick   bool
-	file    string
-	rootKey string
+	ref          string
+	thick        bool
+	file         string
+	rootKey      string
+	fromRegistry string
+	embedBundle  bool
+	images       bool
+	strict       bool
+	// bundle is the canonical bundle pulled from the registry with --from-registry,
+	// and index the descriptor of its OCI image index
+	bundle []byte
+	index  ocispec.Descriptor
+	// relocationMap maps the images of a thin bundle to the digested references they were pushed to
+	relocationMap relocation.ImageRelocationMap
 
 	intoto bool
 	layout string
 	// TODO: figure out a way to pass layout root key to TUF (not in the custom object)
-	layoutKey string
-	linkDir   string
+	layoutKeys      []string
+	layoutThreshold int
+	linkDir         string
+	minValidity     time.Duration
+
+	verifyBeforeSign   bool
+	verifyFinalProduct bool
+	intotoStore        string
+
+	attestations   []string
+	attestationKey string
 }
 
 func newSignCmd() *cobra.Command {
@@ -53,6 +80,23 @@ Example: computes the SHA256 digest of a thick bundle, pushes it to a trust seve
 $ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 sign --thick testdata/cnab/helloworld-0.1.1.tgz localhost:5000/thick-bundle:v1
 INFO[0000] Pushed trust data for localhost:5000/thick-bundle:v1: 540cc4dc213548ebbdffb2ab0ef58729e089d1887edbcde6eeca851de624da70
 
+To sign a thin bundle that was already pushed to a registry, for example by porter, duffle or cnab-to-oci, use --from-registry.
+The bundle is pulled from the registry and canonicalized, and only its digest is pushed to the trust server, under the same reference.
+The registry is not modified:
+
+$ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 sign --from-registry localhost:5000/thin-bundle:v1
+
+To also sign the invocation images and component images referenced by a thin bundle, pass --images. The relocation map
+produced when pushing the bundle, which maps every image of the bundle to the digest it was pushed to, is recorded in the
+trust data, and "signy verify --images" checks that each image is in the registry at its signed digest.
+
+To refuse signing bundles whose images could later resolve to different images, pass --strict. Every invocation image
+and component image must then have a contentDigest and must not use the latest tag, and the bundle must have a valid
+schema version and semantic version. "signy lint" runs the same checks without signing.
+
+To let "signy verify" explain digest mismatches with the differences between the signed and the pulled bundle,
+pass --embed-bundle to embed the signed bundle.json in the trust data.
+
 In order to also push in-toto metadata to the TUF collection, use the --in-toto flag, together with --layout, --links, and (temporarily?) --layout-key.
 
 Example:
@@ -64,34 +108,128 @@ INFO[0000] Starting to copy image cnab/helloworld:0.1.1
 INFO[0001] Completed image cnab/helloworld:0.1.1 copy
 INFO[0001] Generated relocation map: relocation.ImageRelocationMap{"cnab/helloworld:0.1.1":"localhost:5000/thin-intoto@sha256:a59a4e74d9cc89e4e75dfb2cc7ea5c108e4236ba6231b53081a9e2506d1197b6"}
 INFO[0001] Pushed successfully, with digest "sha256:b4936e42304c184bafc9b06dde9ea1f979129e09a021a8f40abc07f736de9268"
+
+So that no single project owner can change the supply chain policy, pass the public key of every layout owner to --layout-key,
+and the number of them that must sign the root layout to --layout-threshold:
+
+$ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 sign testdata/cnab/bundle.json localhost:5000/thin-intoto:v2 --in-toto --layout root.layout --links links --layout-key alice.pub,bob.pub,carol.pub --layout-threshold 2
+
+Signing with an expired layout is refused. To make sure the layout stays valid long enough for consumers to verify
+the artifact, pass the minimum remaining validity to --layout-min-validity (for example, --layout-min-validity 168h).
+
+To refuse publishing in-toto metadata that would fail verification downstream, pass --verify-before-sign.
+This verifies the layout signatures against the layout keys and threshold, and the link signatures against the step keys and thresholds.
+Adding --verify-final-product also runs the full in-toto verification, including inspections, on the OS against the artifact being signed.
+
+By default, the in-toto metadata is embedded in the TUF targets metadata. To keep targets.json small, use --in-toto-store to store
+the metadata out of band, and only its digest and location in TUF:
+
+--in-toto-store oci                    pushes the metadata as an OCI artifact in the repository of the target reference
+--in-toto-store oci://<repository>     pushes the metadata as an OCI artifact in another repository
+--in-toto-store https://<blob-store>   uploads the metadata to a content addressed blob store (PUT <blob-store>/sha256/<hex>)
+--in-toto-store <directory>            writes the metadata to a content addressed local directory, recorded relative to
+                                       the directory: verifiers must pass the same directory to --in-toto-store
+
+To attach in-toto attestations (such as SLSA provenance, SPDX or CycloneDX SBOMs, or test results) to the target, pass
+their DSSE envelopes to --attestation. Attestations must have the artifact being signed as a subject.
+Unsigned in-toto statements are signed with the key passed to --attestation-key, and the artifact becomes their
+subject if they have none:
+
+$ signy --tlscacert=$NOTARY_CA --server https://localhost:4443 sign testdata/cnab/bundle.json localhost:5000/thin-intoto:v2 --attestation provenance.json --attestation-key builder.pem
 `
 	sign := signCmd{}
 	cmd := &cobra.Command{
 		Use:   "sign [file] [target reference]",
 		Short: "Signs an artifact",
 		Long:  signDesc,
-		Args:  cobra.ExactArgs(2),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if sign.fromRegistry != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(2)(cmd, args)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			sign.file = args[0]
-			sign.ref = args[1]
+			if sign.fromRegistry != "" {
+				sign.ref = sign.fromRegistry
+			} else {
+				sign.file = args[0]
+				sign.ref = args[1]
+			}
 			return sign.run()
 		},
 	}
 	cmd.Flags().StringVarP(&sign.rootKey, "root-key", "", "", "Root key to initialize the repository with")
 	cmd.Flags().BoolVarP(&sign.thick, "thick", "", false, "Signs a thick bundle. If passed, only the signature is pushed to the trust server, not the bundle file")
+	cmd.Flags().BoolVarP(&sign.embedBundle, "embed-bundle", "", false, "Embeds the signed bundle.json in the trust data, so that verify can show how a pulled bundle differs from it")
+	cmd.Flags().BoolVarP(&sign.images, "images", "", false, "Signs the digests of the invocation images and component images of a thin bundle, as pushed to the registry")
+	cmd.Flags().BoolVarP(&sign.strict, "strict", "", false, "Refuses to sign a bundle with unpinned or mutable image references, or an invalid schema version or version (see signy lint)")
+	cmd.Flags().StringVarP(&sign.fromRegistry, "from-registry", "", "", "Signs the thin bundle already pushed to this reference, without pushing it again")
 
 	cmd.Flags().BoolVarP(&sign.intoto, "in-toto", "", false, "Adds in-toto metadata to TUF. If passed, the root layout, links directory, and root kyes must be supplied")
 	cmd.Flags().StringVarP(&sign.layout, "layout", "", "", "Path to the in-toto root layout file")
-	cmd.Flags().StringVarP(&sign.linkDir, "links", "", "", "Path to the in-toto links directory")
-	cmd.Flags().StringVarP(&sign.layoutKey, "layout-key", "", "", "Path to the in-toto root layout public keys")
+	cmd.Flags().StringVarP(&sign.linkDir, "links", "", "", "Path to the in-toto links directory (links for sublayouts are read from its <step>.<keyid-prefix> subdirectories)")
+	cmd.Flags().StringSliceVarP(&sign.layoutKeys, "layout-key", "", nil, "Path to the in-toto root layout public keys, one per layout owner")
+	cmd.Flags().IntVarP(&sign.layoutThreshold, "layout-threshold", "", 0, "Number of layout owners that must sign the root layout (defaults to all of them)")
+	cmd.Flags().DurationVarP(&sign.minValidity, "layout-min-validity", "", 0, "Refuses to sign with a layout that expires within this duration")
+	cmd.Flags().StringVarP(&sign.intotoStore, "in-toto-store", "", "", `Stores the in-toto metadata out of band ("oci", "oci://<repository>", an HTTP blob store URL, or a local directory that verifiers pass to --in-toto-store)`)
+	cmd.Flags().StringSliceVarP(&sign.attestations, "attestation", "", nil, "Path to an in-toto attestation (DSSE envelope, or in-toto statement to sign with --attestation-key) to attach to the target")
+	cmd.Flags().StringVarP(&sign.attestationKey, "attestation-key", "", "", "Path to the private key used to sign the in-toto statements passed to --attestation")
+	cmd.Flags().BoolVarP(&sign.verifyBeforeSign, "verify-before-sign", "", false, "Verifies the in-toto layout and link signatures before publishing them")
+	cmd.Flags().BoolVarP(&sign.verifyFinalProduct, "verify-final-product", "", false, "Runs the full in-toto verification on the OS against the artifact before publishing it (implies --verify-before-sign)")
 
 	return cmd
 }
 
 func (s *signCmd) run() error {
+	if (s.verifyBeforeSign || s.verifyFinalProduct) && !s.intoto {
+		return fmt.Errorf("in-toto verification before signing requires --in-toto")
+	}
+	if s.intotoStore != "" && !s.intoto && len(s.attestations) == 0 {
+		return fmt.Errorf("storing in-toto metadata requires --in-toto or --attestation")
+	}
+	if s.embedBundle && s.thick {
+		return fmt.Errorf("only thin bundles can be embedded in the trust data")
+	}
+	if s.images && s.thick {
+		return fmt.Errorf("only the images of thin bundles can be signed")
+	}
+	if s.strict && s.thick {
+		return fmt.Errorf("only thin bundles can be checked with --strict")
+	}
+	if s.fromRegistry != "" {
+		if s.thick {
+			return fmt.Errorf("only thin bundles can be signed from a registry")
+		}
+		// The bundle is pulled by digest, so that the signed bundle is the one of the recorded OCI index.
+		index, err := oci.Resolve(context.Background(), s.fromRegistry)
+		if err != nil {
+			return err
+		}
+		pinned, err := withDigest(s.fromRegistry, index.Digest)
+		if err != nil {
+			return err
+		}
+		log.Infof("Pulling thin bundle from registry: %v", pinned)
+		bun, relocationMap, err := cnab.Pull(pinned)
+		if err != nil {
+			return fmt.Errorf("cannot pull bundle %v: %v", s.fromRegistry, err)
+		}
+		b, err := canonicaljson.Marshal(bun)
+		if err != nil {
+			return err
+		}
+		s.bundle, s.index, s.relocationMap = b, index, relocationMap
+	}
+
+	if s.strict {
+		if err := s.lint(); err != nil {
+			return err
+		}
+	}
+
 	var cm *canonicaljson.RawMessage
 	if s.intoto {
-		if s.layout == "" || s.layoutKey == "" || s.linkDir == "" {
+		if s.layout == "" || len(s.layoutKeys) == 0 || s.linkDir == "" {
 			return fmt.Errorf("required in-toto metadata not found")
 		}
 		log.Infof("Adding In-Toto layout and links metadata to TUF")
@@ -99,7 +237,13 @@ func (s *signCmd) run() error {
 		if err != nil {
 			return fmt.Errorf("validation for in-toto metadata failed: %v", err)
 		}
-		custom, err := intoto.GetMetadataRawMessage(s.layout, s.linkDir, s.layoutKey)
+		if err := intoto.CheckLayoutExpiryFromPath(s.layout, s.minValidity); err != nil {
+			return fmt.Errorf("refusing to sign with the in-toto layout: %v", err)
+		}
+		if err := s.verifyInToto(); err != nil {
+			return err
+		}
+		custom, err := intoto.GetMetadataRawMessage(s.layout, s.linkDir, s.layoutKeys, s.layoutThreshold)
 		if err != nil {
 			return fmt.Errorf("cannot get metadata message: %v", err)
 		}
@@ -107,18 +251,69 @@ func (s *signCmd) run() error {
 		// Refactor GetMatedataRawMessage to return a pointer to a raw message
 		cm = &custom
 	}
+	if len(s.attestations) > 0 {
+		custom, err := s.addAttestations(cm)
+		if err != nil {
+			return err
+		}
+		cm = &custom
+	}
+	if s.intotoStore != "" {
+		store, err := intoto.NewStore(s.intotoStore, s.ref)
+		if err != nil {
+			return fmt.Errorf("cannot create in-toto metadata store: %v", err)
+		}
+		custom, err := intoto.StoreExternal(context.Background(), store, *cm)
+		if err != nil {
+			return err
+		}
+		cm = &custom
+	}
 
 	// NOTE: We first push to the Registry, and then Notary. This is so that if we modify the bundle locally,
 	// we will not invalidate its signature by first pushing to Notary, and then the Registry.
 
-	// We push only thin bundles to the Registry.
+	// We push only thin bundles to the Registry, unless they were pulled from it.
+	if !s.thick && s.bundle == nil {
+		index, relocationMap, err := cnab.Push(s.file, s.ref)
+		if err != nil {
+			return err
+		}
+		s.index, s.relocationMap = index, relocationMap
+	}
+	// The digest of the OCI index of thin bundles is recorded, so that it can be verified directly, instead of
+	// relying only on the digest of the bundle, which is re-marshaled after being pulled.
 	if !s.thick {
-		if err := cnab.Push(s.file, s.ref); err != nil {
+		var custom canonicaljson.RawMessage
+		if cm != nil {
+			custom = *cm
+		}
+		m := cnab.NewBundleMetadata(s.index)
+		if s.embedBundle {
+			b, err := s.readArtifact()
+			if err != nil {
+				return err
+			}
+			m.JSON = b
+		}
+		if s.images {
+			log.Infof("Signing the images of the bundle: %v", s.relocationMap)
+			m.Images = s.relocationMap
+		}
+		custom, err := cnab.BundleCustom(m, custom)
+		if err != nil {
 			return err
 		}
+		cm = &custom
 	}
 
-	target, err := tuf.SignAndPublish(trustDir, trustServer, s.ref, s.file, tlscacert, s.rootKey, timeout, cm)
+	var target *client.Target
+	var err error
+	if s.bundle != nil {
+		target, err = tuf.SignAndPublishBytes(trustDir, trustServer, s.ref, s.bundle, tlscacert, s.rootKey, timeout, cm)
+	} else {
+		target, err = tuf.SignAndPublish(trustDir, trustServer, s.ref, s.file, tlscacert, s.rootKey, timeout, cm)
+	}
 	if err != nil {
 		return fmt.Errorf("cannot sign and publish trust data: %v", err)
 	}
@@ -126,3 +321,86 @@ func (s *signCmd) run() error {
 	log.Infof("Pushed trust data for %v: %v\n", s.ref, hex.EncodeToString(target.Hashes["sha256"]))
 	return nil
 }
+
+func (s *signCmd) verifyInToto() error {
+	if !s.verifyBeforeSign && !s.verifyFinalProduct {
+		return nil
+	}
+
+	var artifact []byte
+	if s.verifyFinalProduct {
+		var err error
+		artifact, err = s.readArtifact()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := intoto.VerifyBeforeSign(s.layout, s.linkDir, s.layoutKeys, s.layoutThreshold, artifact); err != nil {
+		return fmt.Errorf("in-toto verification before signing failed, refusing to publish: %v", err)
+	}
+	return nil
+}
+
+func (s *signCmd) addAttestations(cm *canonicaljson.RawMessage) (canonicaljson.RawMessage, error) {
+	log.Infof("Adding in-toto attestations to TUF")
+	artifact, err := s.readArtifact()
+	if err != nil {
+		return nil, err
+	}
+
+	var key *in_toto.Key
+	if s.attestationKey != "" {
+		k, err := intoto.LoadKey(s.attestationKey, intoto.DefaultKeyScheme)
+		if err != nil {
+			return nil, err
+		}
+		key = &k
+	}
+
+	var envelopes []*intoto.Envelope
+	for _, a := range s.attestations {
+		e, err := intoto.LoadAttestation(a, key, s.ref, artifact)
+		if err != nil {
+			return nil, err
+		}
+		envelopes = append(envelopes, e)
+	}
+
+	var custom canonicaljson.RawMessage
+	if cm != nil {
+		custom = *cm
+	}
+	return intoto.AddAttestations(custom, envelopes)
+}
+
+// readArtifact returns the content of the artifact being signed: the file, or the canonical bundle pulled from the registry.
+func (s *signCmd) readArtifact() ([]byte, error) {
+	if s.bundle != nil {
+		return s.bundle, nil
+	}
+	b, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read artifact: %v", err)
+	}
+	return b, nil
+}
+
+// lint refuses bundles that cannot be signed safely, listing all their problems.
+func (s *signCmd) lint() error {
+	b, err := s.readArtifact()
+	if err != nil {
+		return err
+	}
+	problems, err := lintBundle(b)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		log.Errorf("%v", p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("refusing to sign the bundle: found %v problem(s), see signy lint", len(problems))
+	}
+	return nil
+}
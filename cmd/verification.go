@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/theupdateframework/notary/client"
+
+	"github.com/cnabio/signy/pkg/docker"
+	"github.com/cnabio/signy/pkg/intoto"
+	"github.com/cnabio/signy/pkg/tuf"
+)
+
+// intotoVerification holds the in-toto verification options, shared by the commands verifying artifacts
+type intotoVerification struct {
+	intoto            bool
+	verifyOnOS        bool
+	verificationImage string
+	imageDigests      []string
+	expiryWarning     time.Duration
+	intotoStore       string
+
+	report     string
+	reportFile string
+
+	runtime         string
+	runtimeEndpoint string
+	sandbox         docker.Sandbox
+	memory          string
+}
+
+func newIntotoVerification() intotoVerification {
+	return intotoVerification{sandbox: docker.DefaultSandbox()}
+}
+
+func (i *intotoVerification) addFlags(flags *pflag.FlagSet) {
+	flags.BoolVarP(&i.intoto, "in-toto", "", false, "If passed, will try to fetch in-toto metadata from TUF and perform the verification")
+	flags.BoolVarP(&i.verifyOnOS, "verify-on-os", "", false, "If passed, will run in-toto inspections on the OS instead of in container")
+	flags.StringVarP(&i.verificationImage, "image", "", docker.VerificationImage, "container image to run the in-toto verification")
+	flags.StringSliceVarP(&i.imageDigests, "image-digest", "", nil, "Trusted digests of verification images (<repository>[:<tag>]@sha256:<digest>), instead of their TUF collection")
+	flags.StringVarP(&i.runtime, "runtime", "", docker.DockerRuntime, `Container runtime of the verification container ("docker"|"podman"|"containerd")`)
+	flags.StringVarP(&i.runtimeEndpoint, "runtime-endpoint", "", "", "Socket of the container runtime (defaults to the socket of the runtime)")
+	flags.StringVarP(&i.sandbox.Network, "network", "", i.sandbox.Network, `Network mode of the verification container ("none" disables networking)`)
+	flags.BoolVarP(&i.sandbox.WritableRootfs, "writable-rootfs", "", false, "Allows the verification container to write to its root filesystem")
+	flags.StringSliceVarP(&i.sandbox.CapAdd, "cap-add", "", nil, "Capabilities to add to the verification container (all others are dropped)")
+	flags.StringVarP(&i.memory, "memory", "", units.BytesSize(float64(i.sandbox.Memory)), `Memory limit of the verification container ("0" for no limit)`)
+	flags.Float64VarP(&i.sandbox.CPUs, "cpus", "", i.sandbox.CPUs, "Number of CPUs of the verification container (0 for no limit)")
+	flags.Int64VarP(&i.sandbox.PidsLimit, "pids-limit", "", i.sandbox.PidsLimit, "Maximum number of processes in the verification container (0 for no limit)")
+	flags.DurationVarP(&i.sandbox.Timeout, "verification-timeout", "", i.sandbox.Timeout, "Maximum duration of the verification in container (0 for no timeout)")
+	flags.StringVarP(&i.report, "report", "", "", `Writes a per step and per inspection in-toto verification report ("text"|"json")`)
+	flags.StringVarP(&i.reportFile, "report-file", "", "", "Writes the in-toto verification report to a file instead of stdout")
+	flags.DurationVarP(&i.expiryWarning, "layout-expiry-warning", "", intoto.DefaultExpiryWarning, "Warns if the in-toto root layout expires within this duration")
+	flags.StringVarP(&i.intotoStore, "in-toto-store", "", "", "Local directory of the in-toto metadata, if it was signed with --in-toto-store <directory>")
+}
+
+// context returns the context of the verification, with the local directory of the in-toto metadata, if any.
+func (i *intotoVerification) context() context.Context {
+	ctx := context.Background()
+	if i.intotoStore != "" {
+		ctx = intoto.WithLocalStore(ctx, i.intotoStore)
+	}
+	return ctx
+}
+
+// validate checks the options before anything is pulled, since the verification image
+// has a default value, flags are needed to know whether it was passed.
+func (i *intotoVerification) validate(flags *pflag.FlagSet) error {
+	if i.report != "" && i.report != intoto.TextReport && i.report != intoto.JSONReport {
+		return fmt.Errorf("unknown report format %q", i.report)
+	}
+
+	memory, err := units.RAMInBytes(i.memory)
+	if err != nil {
+		return fmt.Errorf("invalid memory limit: %v", err)
+	}
+	i.sandbox.Memory = memory
+
+	if i.verifyOnOS && flags.Changed("image") {
+		return fmt.Errorf("verification on OS and in container are mutually exclusive")
+	}
+	return nil
+}
+
+// verify performs the in-toto verification of a target, if it was requested, and writes the report.
+func (i *intotoVerification) verify(target *client.TargetWithRole, artifact []byte) error {
+	if !i.intoto {
+		return nil
+	}
+	if target.Custom == nil {
+		return fmt.Errorf("no in-toto metadata found in the trust data for %v", target.Name)
+	}
+
+	var report *intoto.Report
+	var err error
+	if i.verifyOnOS {
+		log.Warn("Running in-toto inspections on the OS instead of in container...")
+		report, err = intoto.VerifyOnOS(i.context(), target, artifact, i.expiryWarning)
+	} else {
+		var rt docker.Runtime
+		if rt, err = docker.NewRuntime(i.runtime, i.runtimeEndpoint); err != nil {
+			return err
+		}
+		var image string
+		if image, err = docker.PinImage(i.verificationImage, i.imageDigests, trustedDigest); err != nil {
+			return err
+		}
+		report, err = intoto.VerifyInContainer(i.context(), target, artifact, i.expiryWarning, intoto.ContainerOptions{
+			Image:   image,
+			Runtime: rt,
+			Sandbox: i.sandbox,
+		})
+	}
+	if report != nil && i.report != "" {
+		if rerr := writeReport(report, i.report, i.reportFile); rerr != nil {
+			log.Errorf("cannot write verification report: %v", rerr)
+		}
+	}
+	return err
+}
+
+// trustedDigest returns the digest of an image from its TUF collection.
+func trustedDigest(ref string) (digest.Digest, error) {
+	_, sha, err := tuf.GetTargetAndSHA(ref, trustServer, tlscacert, trustDir, timeout)
+	if err != nil {
+		return "", err
+	}
+	return digest.NewDigestFromEncoded(digest.SHA256, sha), nil
+}
+
+func writeReport(report *intoto.Report, format, file string) error {
+	if file == "" {
+		return report.WriteReport(os.Stdout, format)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return report.WriteReport(f, format)
+}
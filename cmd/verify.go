@@ -1,24 +1,46 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 
+	cnabBundle "github.com/cnabio/cnab-go/bundle"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/theupdateframework/notary/client"
 
-	"github.com/cnabio/signy/pkg/docker"
+	"github.com/cnabio/signy/pkg/cnab"
 	"github.com/cnabio/signy/pkg/intoto"
+	"github.com/cnabio/signy/pkg/oci"
 	"github.com/cnabio/signy/pkg/tuf"
 )
 
+// resolveBundleIndex and pullThinBundle resolve and pull thin bundles from the registry.
+// They are variables so that tests can replace the registry.
+var (
+	resolveBundleIndex = oci.Resolve
+	pullThinBundle     = tuf.GetThinBundle
+)
+
 type verifyCmd struct {
 	ref       string
 	thick     bool
 	localFile string
+	// signedBundle is a local copy of the signed bundle.json, used to explain digest mismatches
+	signedBundle string
+	images       bool
+
+	intotoVerification
 
-	intoto            bool
-	verifyOnOS        bool
-	verificationImage string
+	attestations      bool
+	attestationKeys   []string
+	attestationPolicy string
 }
 
 func newVerifyCmd() *cobra.Command {
@@ -26,6 +48,16 @@ func newVerifyCmd() *cobra.Command {
 Pulls the metadata for a target from a trusted collection and checks that the trusted digest
 equals the digest of the existing artifact.
 For canonical CNAB bundes, the bundle is pulled from the OCI registry, and the two digests are compared.
+When the digest of the OCI index of the bundle was signed, which "signy sign" does for thin bundles, the digest of the index
+in the registry is compared to it first, and the bundle is pulled by that digest. Since the bundle is re-marshaled after being
+pulled, a mismatch of the digest of the canonical bundle then only produces a warning.
+
+When the digest of the bundle does not match, the differences between the signed bundle and the pulled bundle are shown,
+if the signed bundle.json was embedded in the trust data ("signy sign --embed-bundle") or is passed to --signed-bundle,
+so that changes of image references by a relocation or a cnab-to-oci fixup can be told apart from tampering.
+
+For thin bundles signed with "signy sign --images", --images also checks that every invocation image and component image
+referenced by the bundle is in the registry at its signed digest.
 
 For thick bundles, the --thick flag is required, together with the --local <path-to-thick-bundle>.
 
@@ -58,8 +90,35 @@ INFO[0000] copying file /in-toto/layout.template in container for verification..
 INFO[0000] Loading layout...
 INFO[0000] Loading layout key(s)...
 INFO[0001] The software product passed all verification.
+
+To find out which part of the supply chain broke when the in-toto verification fails, pass --report text or --report json.
+The report shows, for each step, the links found and the links with a valid signature against the threshold, and the result of
+each artifact rule, and for each inspection, the return value of its command and the result of its artifact rules.
+When verifying in container, the steps are checked on the host, and the result of the container is added to the report.
+
+Inspections run commands from the root layout, so the verification container is sandboxed: by default, it has no network,
+a read-only root filesystem, no capabilities, cannot gain privileges, and is limited in memory, CPUs, processes and duration.
+These restrictions can be relaxed with --network, --writable-rootfs, --cap-add, --memory, --cpus, --pids-limit and --verification-timeout.
+The verification container is run by Docker by default, or by Podman or containerd with --runtime, and --runtime-endpoint
+can point to a socket other than the default one of the runtime. With containerd, only the "none" and "host" networks are supported.
+
+To verify the in-toto attestations attached to the target, use the --attestations flag. The attestations must be signed
+by a key passed to --attestation-key (by default, by a functionary key of the in-toto root layout), and have the target as a subject.
+Rules on their predicates can be enforced with --attestation-policy, a YAML file such as:
+
+rules:
+- predicateType: https://slsa.dev/provenance/v0.1
+  required: true
+  match:
+    builder.id: https://ci.example.com/builder@v1
+
+The verification image is run by digest, so that it cannot be replaced by retagging it. Its digest is taken from --image-digest,
+if the image is listed there, or from its own TUF collection on the trust server, and the verification fails if the image
+cannot be pinned this way. An image referenced by digest with --image is run as is.
+
+Verification fails if the in-toto root layout has expired, and warns if it expires within --layout-expiry-warning.
 `
-	verify := verifyCmd{}
+	verify := verifyCmd{intotoVerification: newIntotoVerification()}
 	cmd := &cobra.Command{
 		Use:   "verify [target reference]",
 		Short: "Verifies the trust data for an artifact",
@@ -67,15 +126,21 @@ INFO[0001] The software product passed all verification.
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			verify.ref = args[0]
+			if err := verify.validate(cmd.Flags()); err != nil {
+				return err
+			}
 			return verify.run()
 		},
 	}
 	cmd.Flags().BoolVarP(&verify.thick, "thick", "", false, "Verifies a thick bundle. If passed, only the signature is pulled from the trust server, and is verified against a local thick bundle")
 	cmd.Flags().StringVarP(&verify.localFile, "local", "", "", "Local file to validate the SHA256 against (mandatory for thick bundles)")
+	cmd.Flags().BoolVarP(&verify.images, "images", "", false, "Verifies that the images referenced by the bundle are in the registry at their signed digests")
+	cmd.Flags().StringVarP(&verify.signedBundle, "signed-bundle", "", "", "Local copy of the signed bundle.json, to show how the pulled bundle differs from it when the digests do not match")
 
-	cmd.Flags().BoolVarP(&verify.intoto, "in-toto", "", false, "If passed, will try to fetch in-toto metadata from TUF and perform the verification")
-	cmd.Flags().BoolVarP(&verify.verifyOnOS, "verify-on-os", "", false, "If passed, will run in-toto inspections on the OS instead of in container")
-	cmd.Flags().StringVarP(&verify.verificationImage, "image", "", docker.VerificationImage, "container image to run the in-toto verification")
+	verify.addFlags(cmd.Flags())
+	cmd.Flags().BoolVarP(&verify.attestations, "attestations", "", false, "If passed, will verify the in-toto attestations attached to the target")
+	cmd.Flags().StringSliceVarP(&verify.attestationKeys, "attestation-key", "", nil, "Path to the public keys trusted to sign attestations (defaults to the functionary keys of the in-toto root layout)")
+	cmd.Flags().StringVarP(&verify.attestationPolicy, "attestation-policy", "", "", "Path to a YAML policy on the attestation predicates")
 
 	return cmd
 }
@@ -84,38 +149,184 @@ func (v *verifyCmd) run() error {
 	if v.thick && v.localFile == "" {
 		return fmt.Errorf("no local file provided for thick bundle verification")
 	}
+	if v.thick && v.images {
+		return fmt.Errorf("only the images of thin bundles can be verified")
+	}
 
-	if v.verifyOnOS && v.verificationImage != "" {
-		return fmt.Errorf("verification on OS and in container are mutually exclusive")
+	target, trustedSHA, err := tuf.GetTargetAndSHA(v.ref, trustServer, tlscacert, trustDir, timeout)
+	if err != nil {
+		return err
 	}
 
 	var bundle []byte
-	var err error
 	if v.thick {
-		bundle, err = tuf.GetThickBundle(v.localFile)
+		if bundle, err = tuf.GetThickBundle(v.localFile); err != nil {
+			return err
+		}
+		err = tuf.VerifyTrust(bundle, trustedSHA)
 	} else {
-		bundle, err = tuf.GetThinBundle(v.ref)
+		bundle, err = v.verifyThinBundle(target, trustedSHA)
 	}
 	if err != nil {
 		return err
 	}
 
-	target, trustedSHA, err := tuf.GetTargetAndSHA(v.ref, trustServer, tlscacert, trustDir, timeout)
+	if v.images {
+		if err := verifyImages(target, bundle); err != nil {
+			return err
+		}
+	}
+
+	if v.attestations {
+		var policy *intoto.AttestationPolicy
+		if v.attestationPolicy != "" {
+			if policy, err = intoto.LoadAttestationPolicy(v.attestationPolicy); err != nil {
+				return err
+			}
+		}
+		if err := intoto.VerifyAttestations(v.context(), target, v.attestationKeys, policy); err != nil {
+			return err
+		}
+	}
+
+	return v.verify(target, bundle)
+}
+
+// verifyThinBundle pulls a thin bundle from the registry and verifies it against its trust data. When the digest of the
+// OCI index of the bundle was signed, it is checked first and the bundle is pulled by that digest; the digest of the
+// re-marshaled bundle is then only checked as a secondary check, since it can differ after a lossy round-trip in cnab-go.
+func (v *verifyCmd) verifyThinBundle(target *client.TargetWithRole, trustedSHA string) ([]byte, error) {
+	var custom []byte
+	if target.Custom != nil {
+		custom = *target.Custom
+	}
+	m, err := cnab.LoadBundleMetadata(custom)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if m == nil {
+		bundle, err := pullThinBundle(v.ref)
+		if err != nil {
+			return nil, err
+		}
+		if err := tuf.VerifyTrust(bundle, trustedSHA); err != nil {
+			v.explainMismatch(nil, trustedSHA, bundle)
+			return nil, err
+		}
+		return bundle, nil
 	}
-	err = tuf.VerifyTrust(bundle, trustedSHA)
+
+	index, err := resolveBundleIndex(context.Background(), v.ref)
+	if err != nil {
+		return nil, err
+	}
+	if index.Digest != m.Digest {
+		v.explainIndexMismatch(m, trustedSHA, index.Digest)
+		return nil, fmt.Errorf("the digest of the bundle index from the trusted collection %v is not equal to the digest in the registry %v", m.Digest, index.Digest)
+	}
+	log.Infof("The bundle index digests are equal: %v", m.Digest)
+
+	pinned, err := withDigest(v.ref, m.Digest)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := pullThinBundle(pinned)
+	if err != nil {
+		return nil, err
+	}
+	if err := tuf.VerifyTrust(bundle, trustedSHA); err != nil {
+		log.Warnf("The bundle index digest matches, but the digest of the canonical bundle does not: %v", err)
+		v.explainMismatch(m, trustedSHA, bundle)
+	}
+	return bundle, nil
+}
+
+// verifyImages checks that the images referenced by a verified bundle are in the registry at their signed digests.
+func verifyImages(target *client.TargetWithRole, bundle []byte) error {
+	var custom []byte
+	if target.Custom != nil {
+		custom = *target.Custom
+	}
+	m, err := cnab.LoadBundleMetadata(custom)
 	if err != nil {
 		return err
 	}
+	if m == nil || len(m.Images) == 0 {
+		return fmt.Errorf("the images of the bundle were not signed")
+	}
+	var b cnabBundle.Bundle
+	if err := json.Unmarshal(bundle, &b); err != nil {
+		return fmt.Errorf("cannot decode bundle: %v", err)
+	}
+	return cnab.VerifyImages(context.Background(), &b, m.Images, oci.Resolve)
+}
+
+// explainIndexMismatch pulls the bundle of the index in the registry, by the digest it resolved to,
+// in order to explain how it differs from the signed bundle.
+func (v *verifyCmd) explainIndexMismatch(m *cnab.BundleMetadata, trustedSHA string, d digest.Digest) {
+	pinned, err := withDigest(v.ref, d)
+	if err != nil {
+		log.Warnf("Cannot pull the bundle from the registry to explain the mismatch: %v", err)
+		return
+	}
+	pulled, err := pullThinBundle(pinned)
+	if err != nil {
+		log.Warnf("Cannot pull the bundle from the registry to explain the mismatch: %v", err)
+		return
+	}
+	v.explainMismatch(m, trustedSHA, pulled)
+}
 
-	if v.intoto {
-		if v.verifyOnOS {
-			log.Warn("Running in-toto inspections on the OS instead of in container...")
-			return intoto.VerifyOnOS(target, bundle)
+// explainMismatch logs the differences between the signed bundle and a pulled bundle that does not match
+// the trusted digest. The signed bundle is read from --signed-bundle, or from the trust data if it was embedded
+// when signing. Nothing is logged if neither is available.
+func (v *verifyCmd) explainMismatch(m *cnab.BundleMetadata, trustedSHA string, pulled []byte) {
+	var signed []byte
+	source := "embedded in the trust data"
+	switch {
+	case v.signedBundle != "":
+		b, err := ioutil.ReadFile(v.signedBundle)
+		if err != nil {
+			log.Warnf("Cannot read the signed bundle: %v", err)
+			return
 		}
-		return intoto.VerifyInContainer(target, bundle, v.verificationImage, logLevel)
+		signed, source = b, v.signedBundle
+	case m != nil && len(m.JSON) > 0:
+		signed = m.JSON
+	default:
+		return
+	}
+
+	sum := sha256.Sum256(signed)
+	if hex.EncodeToString(sum[:]) != trustedSHA {
+		log.Warnf("The signed bundle %v does not match the trusted digest either, cannot explain the mismatch", source)
+		return
+	}
+	changes, err := cnab.Diff(signed, pulled)
+	if err != nil {
+		log.Warnf("Cannot compare the signed and pulled bundles: %v", err)
+		return
 	}
+	log.Warnf("The pulled bundle differs from the signed bundle (%v):", source)
+	for _, c := range changes {
+		log.Warnf("  %v", c)
+	}
+	if cnab.OnlyImageReferences(changes) {
+		log.Warnf("Only image references changed, as done by a relocation or a cnab-to-oci fixup")
+	} else {
+		log.Warnf("Fields other than image references changed, which may indicate tampering")
+	}
+}
 
-	return nil
+// withDigest returns the reference of the repository of ref, with the given digest.
+func withDigest(ref string, d digest.Digest) (string, error) {
+	n, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	pinned, err := reference.WithDigest(reference.TrimNamed(n), d)
+	if err != nil {
+		return "", err
+	}
+	return pinned.String(), nil
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/theupdateframework/notary/client"
+
+	"github.com/cnabio/signy/pkg/canonicaljson"
+	"github.com/cnabio/signy/pkg/cnab"
+)
+
+func TestVerifyThinBundleIndexMismatch(t *testing.T) {
+	is := assert.New(t)
+
+	signed := []byte(`{"images":{"web":{"contentDigest":"sha256:aaa","image":"nginx:1.19"}},"name":"app","version":"0.1.0"}`)
+	relocated := []byte(`{"images":{"web":{"contentDigest":"sha256:bbb","image":"nginx:1.19"}},"name":"app","version":"0.1.0"}`)
+	signedIndex := digest.FromString("signed index")
+	registryIndex := digest.FromString("registry index")
+
+	m := cnab.NewBundleMetadata(ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: signedIndex, Size: 10})
+	m.JSON = signed
+	custom, err := cnab.BundleCustom(m, nil)
+	is.NoError(err)
+	raw := canonicaljson.RawMessage(custom)
+	target := &client.TargetWithRole{Target: client.Target{Custom: &raw}}
+	sum := sha256.Sum256(signed)
+
+	resolve, pull := resolveBundleIndex, pullThinBundle
+	defer func() { resolveBundleIndex, pullThinBundle = resolve, pull }()
+	resolveBundleIndex = func(ctx context.Context, ref string) (ocispec.Descriptor, error) {
+		return ocispec.Descriptor{Digest: registryIndex}, nil
+	}
+	pullThinBundle = func(ref string) ([]byte, error) {
+		if ref != "localhost:5000/thin-bundle@"+registryIndex.String() {
+			return nil, fmt.Errorf("unexpected reference %v", ref)
+		}
+		return relocated, nil
+	}
+
+	var out bytes.Buffer
+	stderr := log.StandardLogger().Out
+	log.SetOutput(&out)
+	defer log.SetOutput(stderr)
+
+	v := verifyCmd{ref: "localhost:5000/thin-bundle:v1"}
+	_, err = v.verifyThinBundle(target, hex.EncodeToString(sum[:]))
+	is.Error(err)
+	is.Contains(out.String(), `images.web.contentDigest: \"sha256:aaa\" -> \"sha256:bbb\"`)
+	is.Contains(out.String(), "Only image references changed")
+}
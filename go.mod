@@ -3,6 +3,7 @@ module github.com/cnabio/signy
 go 1.13
 
 require (
+	github.com/Masterminds/semver v1.5.0
 	github.com/cnabio/cnab-go v0.8.2-beta1
 	github.com/containerd/containerd v1.5.18
 	github.com/cyberphone/json-canonicalization v0.0.0-20210303052042-6bc126869bf4
@@ -11,16 +12,22 @@ require (
 	github.com/docker/distribution v2.8.0+incompatible
 	github.com/docker/docker v1.4.2-0.20191021213818-bebd8206285b
 	github.com/docker/go v1.5.1-1
+	github.com/docker/go-units v0.4.0
 	github.com/hashicorp/go-version v1.2.0 // indirect
 	github.com/in-toto/in-toto-golang v0.0.0-20191106170227-857cd1cfa826
 	github.com/oklog/ulid v1.3.1
+	github.com/opencontainers/go-digest v1.0.0
+	github.com/opencontainers/image-spec v1.0.2
+	github.com/opencontainers/runtime-spec v1.0.3-0.20210326190908-1c3f411f0417
 	github.com/opencontainers/selinux v1.9.1 // indirect
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.0.0
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.4.0
 	github.com/stretchr/testify v1.7.0
 	github.com/theupdateframework/notary v0.6.1
 	google.golang.org/grpc v1.42.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 replace github.com/in-toto/in-toto-golang => github.com/radu-matei/in-toto-golang v0.0.0-20210426203218-225046ac7465
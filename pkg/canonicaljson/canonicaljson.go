@@ -2,6 +2,7 @@ package canonicaljson
 
 import (
 	"encoding/json"
+	"fmt"
 
 	canonicaljson "github.com/cyberphone/json-canonicalization/go/src/webpki.org/jsoncanonicalizer"
 	rawmessage "github.com/docker/go/canonical/json" // We are only using this library for the RawMessage type that TUF uses, the actual marshaling is done by the webpki.org/jsoncanonicalizer library
@@ -19,3 +20,31 @@ func Marshal(v interface{}) ([]byte, error) {
 	}
 	return canonicaljson.Transform(b)
 }
+
+// Merge returns the canonical json encoding of the union of the JSON objects a and b.
+// Empty messages are ignored, and a key set in both objects is an error.
+func Merge(a, b RawMessage) (RawMessage, error) {
+	if len(a) == 0 {
+		return b, nil
+	}
+	if len(b) == 0 {
+		return a, nil
+	}
+	var objA, objB map[string]json.RawMessage
+	if err := json.Unmarshal(a, &objA); err != nil {
+		return nil, fmt.Errorf("cannot merge JSON: %v", err)
+	}
+	if err := json.Unmarshal(b, &objB); err != nil {
+		return nil, fmt.Errorf("cannot merge JSON: %v", err)
+	}
+	for k, v := range objB {
+		if _, ok := objA[k]; ok {
+			return nil, fmt.Errorf("cannot merge JSON: key %q is set twice", k)
+		}
+		if objA == nil {
+			objA = make(map[string]json.RawMessage)
+		}
+		objA[k] = v
+	}
+	return Marshal(objA)
+}
@@ -0,0 +1,138 @@
+package cnab
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Change is a difference between the signed bundle and the pulled bundle
+type Change struct {
+	// Path is the path of the changed field, such as images.app.contentDigest or invocationImages[0].image
+	Path string
+	// Signed and Pulled are the values of the field in each bundle, or nil if it is missing from it
+	Signed interface{}
+	Pulled interface{}
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Signed == nil:
+		return fmt.Sprintf("%v: added %v", c.Path, formatValue(c.Pulled))
+	case c.Pulled == nil:
+		return fmt.Sprintf("%v: removed %v", c.Path, formatValue(c.Signed))
+	default:
+		return fmt.Sprintf("%v: %v -> %v", c.Path, formatValue(c.Signed), formatValue(c.Pulled))
+	}
+}
+
+// imageReferenceFields are the fields of images and invocation images that relocations and
+// cnab-to-oci fixups update when they push the images of a bundle.
+var imageReferenceFields = map[string]bool{
+	"image":         true,
+	"contentDigest": true,
+	"size":          true,
+	"mediaType":     true,
+}
+
+// Diff returns the field-level differences between two bundle.json documents, sorted by path.
+func Diff(signed, pulled []byte) ([]Change, error) {
+	var s, p interface{}
+	if err := unmarshalBundle(signed, &s); err != nil {
+		return nil, fmt.Errorf("cannot decode signed bundle: %v", err)
+	}
+	if err := unmarshalBundle(pulled, &p); err != nil {
+		return nil, fmt.Errorf("cannot decode pulled bundle: %v", err)
+	}
+	var changes []Change
+	diffValues("", s, p, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// OnlyImageReferences returns whether all changes are updates of the references of images or invocation images,
+// as made by a relocation or a cnab-to-oci fixup, rather than changes of the bundle itself.
+func OnlyImageReferences(changes []Change) bool {
+	for _, c := range changes {
+		if !strings.HasPrefix(c.Path, "images.") && !strings.HasPrefix(c.Path, "invocationImages[") {
+			return false
+		}
+		field := c.Path[strings.LastIndexAny(c.Path, ".]")+1:]
+		if !imageReferenceFields[field] {
+			return false
+		}
+	}
+	return len(changes) > 0
+}
+
+func unmarshalBundle(b []byte, v *interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func diffValues(path string, s, p interface{}, changes *[]Change) {
+	switch sv := s.(type) {
+	case map[string]interface{}:
+		pv, ok := p.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := map[string]bool{}
+		for k := range sv {
+			keys[k] = true
+		}
+		for k := range pv {
+			keys[k] = true
+		}
+		for k := range keys {
+			diffValues(joinPath(path, k), sv[k], pv[k], changes)
+		}
+		return
+	case []interface{}:
+		pv, ok := p.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(sv) || i < len(pv); i++ {
+			var se, pe interface{}
+			if i < len(sv) {
+				se = sv[i]
+			}
+			if i < len(pv) {
+				pe = pv[i]
+			}
+			diffValues(fmt.Sprintf("%v[%v]", path, i), se, pe, changes)
+		}
+		return
+	}
+	if !equalValues(s, p) {
+		*changes = append(*changes, Change{Path: path, Signed: s, Pulled: p})
+	}
+}
+
+func equalValues(a, b interface{}) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return bytes.Equal(ab, bb)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func formatValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
@@ -0,0 +1,38 @@
+package cnab
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	is := assert.New(t)
+
+	signed := []byte(`{"name":"app","version":"0.1.0","images":{"web":{"image":"nginx:1.19","contentDigest":"sha256:aaa"}},"invocationImages":[{"image":"cnab/app:0.1.0","imageType":"docker"}],"parameters":{"port":{"definition":"port","destination":{"env":"PORT"}}}}`)
+
+	changes, err := Diff(signed, signed)
+	is.NoError(err)
+	is.Empty(changes)
+	is.False(OnlyImageReferences(changes))
+
+	relocated := []byte(`{"name":"app","version":"0.1.0","images":{"web":{"image":"localhost:5000/app@sha256:bbb","contentDigest":"sha256:bbb"}},"invocationImages":[{"image":"localhost:5000/app@sha256:ccc","imageType":"docker","contentDigest":"sha256:ccc"}],"parameters":{"port":{"definition":"port","destination":{"env":"PORT"}}}}`)
+	changes, err = Diff(signed, relocated)
+	is.NoError(err)
+	is.Len(changes, 4)
+	is.Equal(`images.web.contentDigest: "sha256:aaa" -> "sha256:bbb"`, changes[0].String())
+	is.Equal(`invocationImages[0].contentDigest: added "sha256:ccc"`, changes[2].String())
+	is.True(OnlyImageReferences(changes))
+
+	tampered := []byte(`{"name":"app","version":"0.1.1","images":{"web":{"image":"nginx:1.19","contentDigest":"sha256:aaa"}},"invocationImages":[{"image":"cnab/app:0.1.0","imageType":"docker"}],"parameters":{"port":{"definition":"port","destination":{"env":"HOST"}}}}`)
+	changes, err = Diff(signed, tampered)
+	is.NoError(err)
+	is.Equal([]string{
+		`parameters.port.destination.env: "PORT" -> "HOST"`,
+		`version: "0.1.0" -> "0.1.1"`,
+	}, []string{changes[0].String(), changes[1].String()})
+	is.False(OnlyImageReferences(changes))
+
+	_, err = Diff([]byte(`{`), signed)
+	is.Error(err)
+}
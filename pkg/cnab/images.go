@@ -0,0 +1,64 @@
+package cnab
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/docker/cnab-to-oci/relocation"
+	"github.com/docker/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+)
+
+// ResolveFunc resolves an image reference to the descriptor of its manifest in a registry
+type ResolveFunc func(ctx context.Context, ref string) (ocispec.Descriptor, error)
+
+// VerifyImages checks that every image referenced by a bundle is in the signed relocation map,
+// and that the registry serves each signed image at its signed digest.
+func VerifyImages(ctx context.Context, b *bundle.Bundle, images relocation.ImageRelocationMap, resolve ResolveFunc) error {
+	for _, image := range bundleImages(b) {
+		if _, ok := images[image]; !ok {
+			return fmt.Errorf("image %v of the bundle is not signed", image)
+		}
+	}
+
+	var originals []string
+	for original := range images {
+		originals = append(originals, original)
+	}
+	sort.Strings(originals)
+	for _, original := range originals {
+		relocated := images[original]
+		n, err := reference.ParseNormalizedNamed(relocated)
+		if err != nil {
+			return fmt.Errorf("invalid signed reference %v of image %v: %v", relocated, original, err)
+		}
+		d, ok := n.(reference.Digested)
+		if !ok {
+			return fmt.Errorf("signed reference %v of image %v has no digest", relocated, original)
+		}
+		desc, err := resolve(ctx, relocated)
+		if err != nil {
+			return fmt.Errorf("cannot find image %v at its signed digest: %v", original, err)
+		}
+		if desc.Digest != d.Digest() {
+			return fmt.Errorf("registry returned digest %v for image %v, signed with digest %v", desc.Digest, original, d.Digest())
+		}
+		log.Infof("Image %v is in the registry at its signed digest: %v", original, relocated)
+	}
+	return nil
+}
+
+// bundleImages returns the invocation images and component images referenced by a bundle.
+func bundleImages(b *bundle.Bundle) []string {
+	var images []string
+	for _, i := range b.InvocationImages {
+		images = append(images, i.Image)
+	}
+	for _, i := range b.Images {
+		images = append(images, i.Image)
+	}
+	return images
+}
@@ -0,0 +1,53 @@
+package cnab
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/docker/cnab-to-oci/relocation"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyImages(t *testing.T) {
+	is := assert.New(t)
+
+	invocation := digest.FromString("invocation")
+	web := digest.FromString("web")
+	b := &bundle.Bundle{
+		InvocationImages: []bundle.InvocationImage{{BaseImage: bundle.BaseImage{Image: "cnab/app:0.1.0"}}},
+		Images:           map[string]bundle.Image{"web": {BaseImage: bundle.BaseImage{Image: "nginx:1.19"}}},
+	}
+	images := relocation.ImageRelocationMap{
+		"cnab/app:0.1.0": "localhost:5000/app@" + invocation.String(),
+		"nginx:1.19":     "localhost:5000/app@" + web.String(),
+	}
+	registry := map[string]digest.Digest{
+		"localhost:5000/app@" + invocation.String(): invocation,
+		"localhost:5000/app@" + web.String():        web,
+	}
+	resolve := func(ctx context.Context, ref string) (ocispec.Descriptor, error) {
+		d, ok := registry[ref]
+		if !ok {
+			return ocispec.Descriptor{}, fmt.Errorf("%v not found", ref)
+		}
+		return ocispec.Descriptor{Digest: d}, nil
+	}
+
+	is.NoError(VerifyImages(context.Background(), b, images, resolve))
+
+	// every image of the bundle must be signed.
+	b.Images["db"] = bundle.Image{BaseImage: bundle.BaseImage{Image: "postgres:13"}}
+	is.Error(VerifyImages(context.Background(), b, images, resolve))
+	delete(b.Images, "db")
+
+	// signed images must be in the registry at their digest.
+	delete(registry, "localhost:5000/app@"+web.String())
+	is.Error(VerifyImages(context.Background(), b, images, resolve))
+
+	images["nginx:1.19"] = "localhost:5000/app:web"
+	is.Error(VerifyImages(context.Background(), b, images, resolve))
+}
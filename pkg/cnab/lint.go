@@ -0,0 +1,79 @@
+package cnab
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Masterminds/semver"
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// Problem is a reason for a bundle to be refused by strict signing
+type Problem struct {
+	// Field is the path of the field with the problem, such as images.web or invocationImages[0]
+	Field   string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%v: %v", p.Field, p.Message)
+}
+
+// Lint checks that a bundle can be safely signed: it must have a valid schema version and semantic version,
+// and every image it references must be pinned by a content digest, and must not use the mutable latest tag,
+// so that the signed bundle cannot resolve to different images later.
+func Lint(b *bundle.Bundle) []Problem {
+	var problems []Problem
+	if _, err := semver.NewVersion(b.SchemaVersion); err != nil {
+		problems = append(problems, Problem{"schemaVersion", fmt.Sprintf("invalid schema version %q: set it to the version of the CNAB specification of the bundle, such as \"v1.0.0\"", b.SchemaVersion)})
+	}
+	if _, err := semver.NewVersion(b.Version); err != nil {
+		problems = append(problems, Problem{"version", fmt.Sprintf("invalid version %q: set it to a semantic version, such as \"0.1.0\"", b.Version)})
+	}
+
+	if len(b.InvocationImages) == 0 {
+		problems = append(problems, Problem{"invocationImages", "the bundle has no invocation image"})
+	}
+	for i, img := range b.InvocationImages {
+		problems = append(problems, lintImage(fmt.Sprintf("invocationImages[%v]", i), img.BaseImage)...)
+	}
+
+	var names []string
+	for name := range b.Images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems = append(problems, lintImage("images."+name, b.Images[name].BaseImage)...)
+	}
+	return problems
+}
+
+func lintImage(field string, img bundle.BaseImage) []Problem {
+	var problems []Problem
+	ref, err := reference.ParseNormalizedNamed(img.Image)
+	if err != nil {
+		return []Problem{{field, fmt.Sprintf("invalid image reference %q: %v", img.Image, err)}}
+	}
+	tagged, isTagged := ref.(reference.Tagged)
+	digested, isDigested := ref.(reference.Digested)
+	if isTagged && tagged.Tag() == "latest" || !isTagged && !isDigested {
+		problems = append(problems, Problem{field, fmt.Sprintf("image %v uses the mutable latest tag: reference a version tag or a digest instead", img.Image)})
+	}
+
+	if img.Digest == "" {
+		problems = append(problems, Problem{field, fmt.Sprintf("image %v has no contentDigest: set it to the digest of the image manifest, for example from \"docker inspect --format '{{index .RepoDigests 0}}' %v\"", img.Image, img.Image)})
+		return problems
+	}
+	d, err := digest.Parse(img.Digest)
+	if err != nil {
+		problems = append(problems, Problem{field, fmt.Sprintf("invalid contentDigest %q: %v", img.Digest, err)})
+		return problems
+	}
+	if isDigested && digested.Digest() != d {
+		problems = append(problems, Problem{field, fmt.Sprintf("image %v has a digest other than its contentDigest %v", img.Image, d)})
+	}
+	return problems
+}
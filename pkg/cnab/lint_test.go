@@ -0,0 +1,43 @@
+package cnab
+
+import (
+	"testing"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	is := assert.New(t)
+
+	d := digest.FromString("image").String()
+	b := &bundle.Bundle{
+		SchemaVersion:    "v1.0.0",
+		Version:          "0.1.0",
+		InvocationImages: []bundle.InvocationImage{{BaseImage: bundle.BaseImage{Image: "cnab/app:0.1.0", Digest: d}}},
+		Images:           map[string]bundle.Image{"web": {BaseImage: bundle.BaseImage{Image: "nginx@" + d, Digest: d}}},
+	}
+	is.Empty(Lint(b))
+
+	b.Version = "latest"
+	b.InvocationImages[0].Image = "cnab/app"
+	b.InvocationImages[0].Digest = ""
+	b.Images["db"] = bundle.Image{BaseImage: bundle.BaseImage{Image: "postgres:latest", Digest: "sha256:1234"}}
+	b.Images["web"] = bundle.Image{BaseImage: bundle.BaseImage{Image: "nginx@" + d, Digest: digest.FromString("other").String()}}
+
+	var fields []string
+	for _, p := range Lint(b) {
+		fields = append(fields, p.Field)
+	}
+	is.Equal([]string{
+		"version",
+		"invocationImages[0]", // latest tag
+		"invocationImages[0]", // no contentDigest
+		"images.db",           // latest tag
+		"images.db",           // invalid contentDigest
+		"images.web",          // digest mismatch
+	}, fields)
+
+	is.Equal([]Problem{{"schemaVersion", `invalid schema version "": set it to the version of the CNAB specification of the bundle, such as "v1.0.0"`}, {"version", `invalid version "": set it to a semantic version, such as "0.1.0"`}, {"invocationImages", "the bundle has no invocation image"}}, Lint(&bundle.Bundle{}))
+}
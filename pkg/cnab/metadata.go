@@ -0,0 +1,62 @@
+package cnab
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/cnab-to-oci/relocation"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cnabio/signy/pkg/canonicaljson"
+)
+
+// BundleMetadata describes the OCI image index of a thin bundle, as pushed to a registry by cnab-to-oci.
+// It is stored under the "bundle" key of the custom TUF metadata.
+type BundleMetadata struct {
+	MediaType string        `json:"mediaType"`
+	Digest    digest.Digest `json:"digest"`
+	Size      int64         `json:"size"`
+	// JSON is the signed bundle.json, if it was embedded to explain digest mismatches when verifying
+	JSON []byte `json:"json,omitempty"`
+	// Images is the relocation map of the images of the bundle, if they were signed: it maps the images
+	// referenced by the bundle to the digested references they were pushed to.
+	Images relocation.ImageRelocationMap `json:"images,omitempty"`
+}
+
+type bundleCustom struct {
+	Bundle *BundleMetadata `json:"bundle,omitempty"`
+}
+
+// NewBundleMetadata returns the metadata of a bundle pushed as the given OCI index.
+func NewBundleMetadata(index ocispec.Descriptor) BundleMetadata {
+	return BundleMetadata{MediaType: index.MediaType, Digest: index.Digest, Size: index.Size}
+}
+
+// BundleCustom returns the custom TUF metadata recording the OCI index of a bundle, merged with the other
+// custom metadata, such as the in-toto metadata.
+func BundleCustom(m BundleMetadata, custom canonicaljson.RawMessage) (canonicaljson.RawMessage, error) {
+	b, err := canonicaljson.Marshal(bundleCustom{Bundle: &m})
+	if err != nil {
+		return nil, err
+	}
+	return canonicaljson.Merge(custom, b)
+}
+
+// LoadBundleMetadata returns the OCI index metadata from custom TUF metadata,
+// or nil for bundles signed without it.
+func LoadBundleMetadata(custom []byte) (*BundleMetadata, error) {
+	c := bundleCustom{}
+	if len(custom) > 0 {
+		if err := json.Unmarshal(custom, &c); err != nil {
+			return nil, fmt.Errorf("cannot decode bundle metadata: %v", err)
+		}
+	}
+	if c.Bundle == nil {
+		return nil, nil
+	}
+	if err := c.Bundle.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid bundle digest %q: %v", c.Bundle.Digest, err)
+	}
+	return c.Bundle, nil
+}
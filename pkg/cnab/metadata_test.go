@@ -0,0 +1,28 @@
+package cnab
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundleCustom(t *testing.T) {
+	is := assert.New(t)
+
+	m, err := LoadBundleMetadata([]byte(`{"layout":"bGF5b3V0"}`))
+	is.NoError(err)
+	is.Nil(m)
+
+	d := digest.FromString("index")
+	custom, err := BundleCustom(NewBundleMetadata(ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: d, Size: 5}), []byte(`{"layout":"bGF5b3V0"}`))
+	is.NoError(err)
+	is.Equal(`{"bundle":{"digest":"`+d.String()+`","mediaType":"application/vnd.oci.image.index.v1+json","size":5},"layout":"bGF5b3V0"}`, string(custom))
+	m, err = LoadBundleMetadata(custom)
+	is.NoError(err)
+	is.Equal(d, m.Digest)
+
+	_, err = LoadBundleMetadata([]byte(`{"bundle":{"digest":"sha256:1234"}}`))
+	is.Error(err)
+}
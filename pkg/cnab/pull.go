@@ -4,22 +4,23 @@ import (
 	"context"
 
 	"github.com/cnabio/cnab-go/bundle"
+	"github.com/docker/cnab-to-oci/relocation"
 	"github.com/docker/cnab-to-oci/remotes"
 	"github.com/docker/distribution/reference"
 	log "github.com/sirupsen/logrus"
 )
 
-// Pull pulls a bundle from an OCI registry
-func Pull(ref string) (*bundle.Bundle, error) {
+// Pull pulls a bundle from an OCI registry, and returns it with the relocation map of its images
+func Pull(ref string) (*bundle.Bundle, relocation.ImageRelocationMap, error) {
 	n, err := reference.ParseNormalizedNamed(ref)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	b, relocationMap, err := remotes.Pull(context.Background(), n, createResolver(nil))
 	log.Debugf("Relocation map: %v", relocationMap)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return b, nil
+	return b, relocationMap, nil
 }
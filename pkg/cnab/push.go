@@ -8,33 +8,36 @@ import (
 	"os"
 
 	"github.com/cnabio/cnab-go/bundle"
+	"github.com/docker/cnab-to-oci/relocation"
 	"github.com/docker/cnab-to-oci/remotes"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 )
 
-// Push pushes a bundle to an OCI registry
-func Push(bundleFile, ref string) error {
+// Push pushes a bundle to an OCI registry, and returns the descriptor of its OCI image index,
+// and the relocation map of its images
+func Push(bundleFile, ref string) (ocispec.Descriptor, relocation.ImageRelocationMap, error) {
 	buf, err := ioutil.ReadFile(bundleFile)
 	if err != nil {
-		return fmt.Errorf("cannot read bundle file: %v", err)
+		return ocispec.Descriptor{}, nil, fmt.Errorf("cannot read bundle file: %v", err)
 	}
 
 	var b bundle.Bundle
 	if err = json.Unmarshal(buf, &b); err != nil {
-		return err
+		return ocispec.Descriptor{}, nil, err
 	}
 
 	resolver := createResolver(nil)
 	n, err := reference.ParseNormalizedNamed(ref)
 	if err != nil {
-		return err
+		return ocispec.Descriptor{}, nil, err
 	}
 
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
-		return err
+		return ocispec.Descriptor{}, nil, err
 	}
 
 	fixupOpts := []remotes.FixupOption{
@@ -48,15 +51,15 @@ func Push(bundleFile, ref string) error {
 
 	relocationMap, err := remotes.FixupBundle(context.Background(), &b, n, resolver, fixupOpts...)
 	if err != nil {
-		return err
+		return ocispec.Descriptor{}, nil, err
 	}
 
 	log.Infof("Generated relocation map: %#v", relocationMap)
 	d, err := remotes.Push(context.Background(), &b, relocationMap, n, resolver, true)
 	if err != nil {
-		return err
+		return ocispec.Descriptor{}, nil, err
 	}
 
 	log.Infof("Pushed successfully, with digest %q\n", d.Digest)
-	return nil
+	return d, relocationMap, nil
 }
@@ -0,0 +1,313 @@
+//go:build linux
+// +build linux
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/oci"
+	containerdRemotes "github.com/containerd/containerd/remotes"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cnab-to-oci/remotes"
+	"github.com/docker/distribution/reference"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultContainerdEndpoint = "/run/containerd/containerd.sock"
+	containerdNamespace       = "signy"
+	cpuPeriod                 = 100000
+)
+
+// containerdRuntime runs the verification containers with containerd. Since containerd has no volumes,
+// the working directory is a host directory bind mounted in the container, and populated with the
+// content of the working directory of the image.
+type containerdRuntime struct {
+	client     *containerd.Client
+	containers map[string]*containerdContainer
+}
+
+type containerdContainer struct {
+	container containerd.Container
+	dir       string
+	task      containerd.Task
+	exitc     chan Exit
+	stdout    *io.PipeReader
+	stdoutW   *io.PipeWriter
+}
+
+func newContainerdRuntime(endpoint string) (Runtime, error) {
+	if endpoint == "" {
+		endpoint = defaultContainerdEndpoint
+	}
+	client, err := containerd.New(strings.TrimPrefix(endpoint, "unix://"), containerd.WithDefaultNamespace(containerdNamespace))
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to containerd: %v", err)
+	}
+	return &containerdRuntime{client: client, containers: make(map[string]*containerdContainer)}, nil
+}
+
+func (c *containerdRuntime) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	image, err := c.getImage(ctx, spec.Image)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := ioutil.TempDir("", "in-toto-container")
+	if err != nil {
+		return "", err
+	}
+	opts, err := specOpts(image, spec, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	cont, err := c.client.NewContainer(ctx, spec.Name,
+		containerd.WithImage(image),
+		containerd.WithNewSnapshot(spec.Name, image),
+		containerd.WithNewSpec(opts...))
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("cannot create container: %v", err)
+	}
+	c.containers[spec.Name] = &containerdContainer{container: cont, dir: dir, exitc: make(chan Exit, 1)}
+
+	// The working directory of the image, which contains the verification script, is hidden by the bind mount.
+	mounts, err := c.client.SnapshotService(containerd.DefaultSnapshotter).Mounts(ctx, spec.Name)
+	if err == nil {
+		err = mount.WithTempMount(ctx, mounts, func(root string) error {
+			return copyDir(filepath.Join(root, spec.WorkingDir), dir)
+		})
+	}
+	if err != nil {
+		c.Remove(context.Background(), spec.Name)
+		return "", fmt.Errorf("cannot copy working directory of image %v: %v", spec.Image, err)
+	}
+	return spec.Name, nil
+}
+
+func (c *containerdRuntime) getImage(ctx context.Context, name string) (containerd.Image, error) {
+	ref, err := reference.ParseDockerRef(name)
+	if err != nil {
+		return nil, err
+	}
+	image, err := c.client.GetImage(ctx, ref.String())
+	switch {
+	case errdefs.IsNotFound(err):
+		log.Errorf("Unable to find image '%s' locally", name)
+		image, err = c.client.Pull(ctx, ref.String(), containerd.WithPullUnpack, containerd.WithResolver(createResolver(nil)))
+		if err != nil {
+			return nil, fmt.Errorf("cannot pull image %v: %v", name, err)
+		}
+		return image, nil
+	case err != nil:
+		return nil, err
+	}
+
+	unpacked, err := image.IsUnpacked(ctx, containerd.DefaultSnapshotter)
+	if err != nil {
+		return nil, err
+	}
+	if !unpacked {
+		if err := image.Unpack(ctx, containerd.DefaultSnapshotter); err != nil {
+			return nil, fmt.Errorf("cannot unpack image %v: %v", name, err)
+		}
+	}
+	return image, nil
+}
+
+// specOpts translates the sandbox into the OCI runtime spec of the container.
+func specOpts(image containerd.Image, spec ContainerSpec, dir string) ([]oci.SpecOpts, error) {
+	s := spec.Sandbox
+	opts := []oci.SpecOpts{
+		oci.WithImageConfig(image),
+		oci.WithProcessCwd(spec.WorkingDir),
+		oci.WithCapabilities(capabilities(s.CapAdd)),
+		oci.WithNoNewPrivileges,
+		oci.WithMounts([]specs.Mount{
+			{
+				Destination: "/tmp",
+				Type:        "tmpfs",
+				Source:      "tmpfs",
+				Options:     []string{"nosuid", "noexec", "nodev", "size=64m"},
+			},
+			{
+				Destination: spec.WorkingDir,
+				Type:        "bind",
+				Source:      dir,
+				Options:     []string{"rbind", "rw"},
+			},
+		}),
+	}
+	if !s.WritableRootfs {
+		opts = append(opts, oci.WithRootFSReadonly())
+	}
+
+	// Containers have their own network namespace by default, with only a loopback interface.
+	switch s.Network {
+	case "none", "":
+	case "host":
+		opts = append(opts, oci.WithHostNamespace(specs.NetworkNamespace), oci.WithHostHostsFile, oci.WithHostResolvconf)
+	default:
+		return nil, fmt.Errorf("network mode %q is not supported by containerd", s.Network)
+	}
+
+	if s.Memory > 0 {
+		opts = append(opts, oci.WithMemoryLimit(uint64(s.Memory)))
+	}
+	if s.CPUs > 0 {
+		opts = append(opts, oci.WithCPUCFS(int64(s.CPUs*cpuPeriod), cpuPeriod))
+	}
+	if s.PidsLimit > 0 {
+		opts = append(opts, oci.WithPidsLimit(s.PidsLimit))
+	}
+	return opts, nil
+}
+
+// capabilities returns the capabilities of the container, in the format of the OCI runtime spec.
+func capabilities(capAdd []string) []string {
+	caps := []string{}
+	for _, c := range capAdd {
+		c = strings.ToUpper(c)
+		if !strings.HasPrefix(c, "CAP_") {
+			c = "CAP_" + c
+		}
+		caps = append(caps, c)
+	}
+	return caps
+}
+
+func (c *containerdRuntime) Copy(ctx context.Context, id string, files map[string][]byte) error {
+	cont, ok := c.containers[id]
+	if !ok {
+		return fmt.Errorf("no such container: %v", id)
+	}
+	for p, b := range files {
+		dst := filepath.Join(cont.dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dst, b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *containerdRuntime) Wait(ctx context.Context, id string) <-chan Exit {
+	cont, ok := c.containers[id]
+	if !ok {
+		exitc := make(chan Exit, 1)
+		exitc <- Exit{Err: fmt.Errorf("no such container: %v", id)}
+		return exitc
+	}
+	return cont.exitc
+}
+
+func (c *containerdRuntime) Start(ctx context.Context, id string) error {
+	cont, ok := c.containers[id]
+	if !ok {
+		return fmt.Errorf("no such container: %v", id)
+	}
+	cont.stdout, cont.stdoutW = io.Pipe()
+	task, err := cont.container.NewTask(ctx, cio.NewCreator(cio.WithStreams(nil, cont.stdoutW, cont.stdoutW)))
+	if err != nil {
+		return err
+	}
+	cont.task = task
+
+	statusc, err := task.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		var exit Exit
+		select {
+		case status := <-statusc:
+			code, _, err := status.Result()
+			exit = Exit{StatusCode: int64(code), Err: err}
+		case <-ctx.Done():
+			// The process is killed when the container is removed.
+			cont.exitc <- Exit{Err: ctx.Err()}
+			return
+		}
+		// The output is complete once the process exited and its streams were copied.
+		task.IO().Wait()
+		cont.stdoutW.Close()
+		cont.exitc <- exit
+	}()
+
+	return task.Start(ctx)
+}
+
+func (c *containerdRuntime) Logs(ctx context.Context, id string) (io.ReadCloser, error) {
+	cont, ok := c.containers[id]
+	if !ok || cont.stdout == nil {
+		return nil, fmt.Errorf("container %v is not started", id)
+	}
+	return cont.stdout, nil
+}
+
+func (c *containerdRuntime) Remove(ctx context.Context, id string) error {
+	cont, ok := c.containers[id]
+	if !ok {
+		return fmt.Errorf("no such container: %v", id)
+	}
+	delete(c.containers, id)
+	defer os.RemoveAll(cont.dir)
+
+	if cont.task != nil {
+		if _, err := cont.task.Delete(ctx, containerd.WithProcessKill); err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+		if cont.stdoutW != nil {
+			cont.stdoutW.Close()
+		}
+	}
+	return cont.container.Delete(ctx, containerd.WithSnapshotCleanup)
+}
+
+// copyDir copies the regular files of a directory tree, if the source exists.
+func copyDir(src, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode().IsRegular():
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(target, b, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func createResolver(insecureRegistries []string) containerdRemotes.Resolver {
+	return remotes.CreateResolver(config.LoadDefaultConfigFile(os.Stderr), insecureRegistries...)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package docker
+
+import "fmt"
+
+func newContainerdRuntime(endpoint string) (Runtime, error) {
+	return nil, fmt.Errorf("the containerd runtime is only supported on Linux")
+}
@@ -2,14 +2,11 @@ package docker
 
 import (
 	"archive/tar"
-	"bufio"
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"math/rand"
+	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/docker/cli/cli/command"
 	cliflags "github.com/docker/cli/cli/flags"
@@ -18,58 +15,67 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/docker/registry"
-	"github.com/oklog/ulid"
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	Tag               string
-	VerificationImage = "cnabio/signy-in-toto-verifier:" + Tag
-)
-
-const (
-	workingDir = "/in-toto" // Where we expect to copy in-toto artifacts to
-)
+// dockerRuntime runs the verification containers with the Docker Engine API,
+// which is also served by the Podman socket.
+type dockerRuntime struct {
+	cli command.Cli
+}
 
-// Run will start a container, copy all In-Toto metadata in /in-toto
-// then run in-toto-verification
-func Run(verificationImage, verificationDir, logLevel string) error {
-	ctx := context.Background()
-	cli, err := initializeDockerCli()
+func newDockerRuntime(endpoint string) (Runtime, error) {
+	cli, err := initializeDockerCli(endpoint)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return &dockerRuntime{cli: cli}, nil
+}
+
+// defaultPodmanEndpoint returns the socket of the rootless Podman service if it exists,
+// and the socket of the system service otherwise.
+func defaultPodmanEndpoint() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		sock := filepath.Join(dir, "podman", "podman.sock")
+		if _, err := os.Stat(sock); err == nil {
+			return "unix://" + sock
+		}
 	}
+	return "unix:///run/podman/podman.sock"
+}
 
+func (d *dockerRuntime) Create(ctx context.Context, spec ContainerSpec) (string, error) {
 	cfg := &container.Config{
-		Image:        verificationImage,
-		WorkingDir:   workingDir,
+		Image:        spec.Image,
+		WorkingDir:   spec.WorkingDir,
 		AttachStderr: true,
 		AttachStdout: true,
-		Tty:          true,
+		Tty:          false,
+		// The working directory is an anonymous volume, so that the metadata can be copied
+		// in it even though the root filesystem is read-only.
+		Volumes: map[string]struct{}{spec.WorkingDir: {}},
 	}
+	hostConfig := spec.Sandbox.hostConfig()
 
-	name := fmt.Sprintf("intoto-verifications-%s", getULID())
-	resp, err := cli.Client().ContainerCreate(ctx, cfg, &container.HostConfig{}, nil, name)
+	resp, err := d.cli.Client().ContainerCreate(ctx, cfg, hostConfig, nil, spec.Name)
 	switch {
 	case client.IsErrNotFound(err):
-		log.Errorf("Unable to find image '%s' locally", verificationImage)
-		if err := pullImage(ctx, cli, verificationImage); err != nil {
-			return err
+		log.Errorf("Unable to find image '%s' locally", spec.Image)
+		if err := pullImage(ctx, d.cli, spec.Image); err != nil {
+			return "", err
 		}
-		if resp, err = cli.Client().ContainerCreate(ctx, cfg, &container.HostConfig{}, nil, ""); err != nil {
-			return fmt.Errorf("cannot create container: %v", err)
+		if resp, err = d.cli.Client().ContainerCreate(ctx, cfg, hostConfig, nil, spec.Name); err != nil {
+			return "", fmt.Errorf("cannot create container: %v", err)
 		}
 	case err != nil:
-		return fmt.Errorf("cannot create container: %v", err)
+		return "", fmt.Errorf("cannot create container: %v", err)
 	}
+	return resp.ID, nil
+}
 
-	defer cli.Client().ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{})
-
-	files, err := buildFileMap(verificationDir)
-	if err != nil {
-		return err
-	}
+func (d *dockerRuntime) Copy(ctx context.Context, id string, files map[string][]byte) error {
 	arch, err := generateArchive(files)
 	if err != nil {
 		return err
@@ -77,58 +83,58 @@ func Run(verificationImage, verificationDir, logLevel string) error {
 	copyOpts := types.CopyToContainerOptions{
 		AllowOverwriteDirWithFile: false,
 	}
-	err = cli.Client().CopyToContainer(ctx, resp.ID, "/", arch, copyOpts)
-	if err != nil {
-		return err
-	}
+	return d.cli.Client().CopyToContainer(ctx, id, workingDir, arch, copyOpts)
+}
 
-	if err = cli.Client().ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return fmt.Errorf("cannot start container: %v", err)
-	}
+func (d *dockerRuntime) Wait(ctx context.Context, id string) <-chan Exit {
+	exitc := make(chan Exit, 1)
+	statusc, errc := d.cli.Client().ContainerWait(ctx, id, container.WaitConditionNextExit)
 	go func() {
-		reader, err := cli.Client().ContainerLogs(context.Background(), resp.ID, types.ContainerLogsOptions{
-			ShowStdout: true,
-			ShowStderr: true,
-			Follow:     true,
-			Timestamps: false,
-		})
-		if err != nil {
-			panic(err)
-		}
-		defer reader.Close()
-
-		scanner := bufio.NewScanner(reader)
-		for scanner.Scan() {
-			log.Infof(scanner.Text())
+		select {
+		case err := <-errc:
+			exitc <- Exit{Err: err}
+		case s := <-statusc:
+			if s.Error != nil {
+				exitc <- Exit{StatusCode: s.StatusCode, Err: fmt.Errorf("container exit code %v: %v", s.StatusCode, s.Error.Message)}
+				return
+			}
+			exitc <- Exit{StatusCode: s.StatusCode}
 		}
 	}()
+	return exitc
+}
 
-	statusc, errc := cli.Client().ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
-	select {
-	case err := <-errc:
-		if err != nil {
-			return fmt.Errorf("error in container: %v", err)
-		}
-	case s := <-statusc:
-		if s.Error != nil {
-			return fmt.Errorf("container exit code %v: %v", s.StatusCode, err)
-		}
-	}
-	return nil
+func (d *dockerRuntime) Start(ctx context.Context, id string) error {
+	return d.cli.Client().ContainerStart(ctx, id, types.ContainerStartOptions{})
 }
 
-func pullImage(ctx context.Context, cli command.Cli, image string) error {
-	ref, err := reference.ParseNormalizedNamed(image)
+func (d *dockerRuntime) Logs(ctx context.Context, id string) (io.ReadCloser, error) {
+	reader, err := d.cli.Client().ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: false,
+	})
 	if err != nil {
-		return err
-	}
-	repo, err := registry.ParseRepositoryInfo(ref)
-	if err != nil {
-		return err
+		return nil, err
 	}
 
-	authCfg := command.ResolveAuthConfig(ctx, cli, repo.Index)
-	encodedAuth, err := command.EncodeAuthToBase64(authCfg)
+	// Without a TTY, stdout and stderr are multiplexed in the log stream.
+	r, w := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(w, w, reader)
+		reader.Close()
+		w.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+func (d *dockerRuntime) Remove(ctx context.Context, id string) error {
+	return d.cli.Client().ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+}
+
+func pullImage(ctx context.Context, cli command.Cli, image string) error {
+	encodedAuth, err := registryAuth(ctx, cli, image)
 	if err != nil {
 		return err
 	}
@@ -146,13 +152,41 @@ func pullImage(ctx context.Context, cli command.Cli, image string) error {
 	return jsonmessage.DisplayJSONMessagesStream(responseBody, cli.Out(), cli.Out().FD(), false, nil)
 }
 
-func initializeDockerCli() (command.Cli, error) {
+// RegistryAuth returns the encoded credentials for the registry of an image, resolved from the Docker
+// configuration file and credential helpers in the same way as the Docker CLI.
+func RegistryAuth(ctx context.Context, image string) (string, error) {
+	cli, err := initializeDockerCli("")
+	if err != nil {
+		return "", err
+	}
+	return registryAuth(ctx, cli, image)
+}
+
+func registryAuth(ctx context.Context, cli command.Cli, image string) (string, error) {
+	ref, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+	repo, err := registry.ParseRepositoryInfo(ref)
+	if err != nil {
+		return "", err
+	}
+
+	authCfg := command.ResolveAuthConfig(ctx, cli, repo.Index)
+	return command.EncodeAuthToBase64(authCfg)
+}
+
+func initializeDockerCli(endpoint string) (command.Cli, error) {
 	cli, err := command.NewDockerCli()
 	if err != nil {
 		return nil, err
 	}
 
-	if err := cli.Initialize(cliflags.NewClientOptions()); err != nil {
+	opts := cliflags.NewClientOptions()
+	if endpoint != "" {
+		opts.Common.Hosts = []string{endpoint}
+	}
+	if err := cli.Initialize(opts); err != nil {
 		return nil, err
 	}
 	return cli, nil
@@ -165,7 +199,6 @@ func generateArchive(files map[string][]byte) (io.Reader, error) {
 
 	go func() {
 		for p, c := range files {
-			log.Infof("copying file %v in container for verification...", p)
 			hdr := &tar.Header{
 				Name: p,
 				Mode: 0644,
@@ -180,27 +213,3 @@ func generateArchive(files map[string][]byte) (io.Reader, error) {
 
 	return r, nil
 }
-
-func buildFileMap(verificationDir string) (map[string][]byte, error) {
-	files := make(map[string][]byte)
-
-	filenames, err := ioutil.ReadDir(verificationDir)
-	if err != nil {
-		return nil, err
-	}
-	for _, filename := range filenames {
-		b, err := ioutil.ReadFile(filepath.Join(verificationDir, filename.Name()))
-		if err != nil {
-			return nil, err
-		}
-		files[filepath.Join(workingDir, filename.Name())] = b
-	}
-
-	return files, nil
-}
-
-func getULID() string {
-	t := time.Unix(1000000, 0)
-	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
-	return ulid.MustNew(ulid.Timestamp(t), entropy).String()
-}
@@ -1,8 +1,14 @@
 package docker
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -12,6 +18,131 @@ var testDir = "../../testdata/intoto"
 func TestRun(t *testing.T) {
 	// NOTE: Tag will be empty since we cannot inject build-time variables during testing.
 	// Therefore, we shall use the "latest" tag.
-	err := Run(VerificationImage+"latest", testDir, log.InfoLevel.String())
+	rt, err := NewRuntime(DockerRuntime, "")
 	assert.NoError(t, err)
+	err = Run(rt, VerificationImage+"latest", testDir, DefaultSandbox())
+	assert.NoError(t, err)
+}
+
+func TestRunFake(t *testing.T) {
+	is := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	rt := NewFakeRuntime(func(spec ContainerSpec, files map[string][]byte) (int64, string) {
+		if _, ok := files["root.layout"]; !ok {
+			return 1, "Verification failed\n"
+		}
+		return 0, "The software product passed all verification.\n"
+	})
+	is.NoError(Run(rt, "verifier:test", testDir, DefaultSandbox()))
+	c := rt.Containers["fake-1"]
+	is.Equal("verifier:test", c.Spec.Image)
+	is.Equal(workingDir, c.Spec.WorkingDir)
+	is.Contains(c.Files, "alice.pub")
+	is.True(c.Started)
+	is.True(c.Removed)
+
+	emptyDir, err := ioutil.TempDir("", "in-toto")
+	is.NoError(err)
+	defer os.RemoveAll(emptyDir)
+	err = Run(rt, "verifier:test", emptyDir, DefaultSandbox())
+	exitErr, ok := err.(*ExitError)
+	is.True(ok)
+	is.Equal(int64(1), exitErr.StatusCode)
+	is.Equal("Verification failed\n", exitErr.Output)
+	is.True(rt.Containers["fake-2"].Removed)
+
+	_, err = NewRuntime("rkt", "")
+	is.Error(err)
+}
+
+func TestCollectOutput(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	output, err := collectOutput(strings.NewReader("Loading layout...\nVerification failed\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Loading layout...\nVerification failed\n", output)
+
+	output, err = collectOutput(strings.NewReader(strings.Repeat("a", 1024) + "\n" + strings.Repeat("b\n", maxOutputSize/2)))
+	assert.NoError(t, err)
+	assert.Len(t, output, maxOutputSize)
+	assert.True(t, strings.HasPrefix(output, "b\n"))
+}
+
+func TestSandboxHostConfig(t *testing.T) {
+	hc := DefaultSandbox().hostConfig()
+	assert.True(t, hc.NetworkMode.IsNone())
+	assert.True(t, hc.ReadonlyRootfs)
+	assert.Equal(t, []string{"ALL"}, []string(hc.CapDrop))
+	assert.Contains(t, hc.SecurityOpt, "no-new-privileges")
+	assert.Equal(t, int64(1e9), hc.NanoCPUs)
+	assert.Equal(t, int64(256), *hc.PidsLimit)
+
+	hc = Sandbox{Network: "bridge", WritableRootfs: true}.hostConfig()
+	assert.False(t, hc.ReadonlyRootfs)
+	assert.Nil(t, hc.PidsLimit)
+	assert.Zero(t, hc.Memory)
+}
+
+func TestPinImage(t *testing.T) {
+	is := assert.New(t)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	d := digest.FromString("verifier")
+	other := digest.FromString("other")
+	trusted := func(ref string) (digest.Digest, error) {
+		if ref != "docker.io/cnabio/verifier:v1" {
+			return "", fmt.Errorf("no trust data for %v", ref)
+		}
+		return d, nil
+	}
+
+	// images referenced by digest are already pinned.
+	image, err := PinImage("cnabio/verifier@"+other.String(), nil, nil)
+	is.NoError(err)
+	is.Equal("docker.io/cnabio/verifier@"+other.String(), image)
+
+	image, err = PinImage("cnabio/verifier:v1", nil, trusted)
+	is.NoError(err)
+	is.Equal("docker.io/cnabio/verifier@"+d.String(), image)
+
+	// pinned digests take precedence over the trusted digests.
+	image, err = PinImage("cnabio/verifier:v1", []string{"cnabio/verifier:v2@" + d.String(), "cnabio/verifier@" + other.String()}, trusted)
+	is.NoError(err)
+	is.Equal("docker.io/cnabio/verifier@"+other.String(), image)
+
+	// an image that cannot be pinned is not run.
+	_, err = PinImage("cnabio/verifier:v2", nil, trusted)
+	is.Error(err)
+	_, err = PinImage("cnabio/verifier:v2", nil, nil)
+	is.Error(err)
+	_, err = PinImage("cnabio/verifier:v1", []string{"cnabio/verifier:v1"}, trusted)
+	is.Error(err)
+}
+
+func TestPulledDigest(t *testing.T) {
+	is := assert.New(t)
+	d := digest.FromString("image")
+
+	output := `{"status":"Pulling from x","id":"v1"}
+{"status":"Digest: ` + d.String() + `"}
+{"status":"Status: Downloaded newer image for localhost:5000/x:v1"}
+`
+	pulled, err := ReadPullOutput(strings.NewReader(output))
+	is.NoError(err)
+	is.Equal(d, pulled)
+
+	_, err = ReadPullOutput(strings.NewReader(`{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}`))
+	is.Error(err)
+
+	// repositories are compared by name, even with registry ports or prefixes of other repositories.
+	ref, err := reference.ParseNormalizedNamed("localhost:5000/x:v1")
+	is.NoError(err)
+	is.True(HasRepoDigest(ref, []string{"localhost:5000/xy@" + digest.FromString("other").String(), "localhost:5000/x@" + d.String()}, d))
+	is.False(HasRepoDigest(ref, []string{"localhost:5000/xy@" + d.String()}, d))
+	is.False(HasRepoDigest(ref, []string{"localhost:5000/x@" + digest.FromString("other").String()}, d))
+	is.False(HasRepoDigest(ref, nil, d))
 }
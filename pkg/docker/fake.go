@@ -0,0 +1,121 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// FakeRuntime is an in-memory container runtime, so that the verification logic can be tested
+// without a container engine. Instead of running the image, it calls Verify with the files copied in the container.
+type FakeRuntime struct {
+	// Verify returns the exit status code and the output of the container
+	Verify func(spec ContainerSpec, files map[string][]byte) (int64, string)
+	// Err is returned by Create if it is not nil
+	Err error
+	// Containers are the containers created, by ID
+	Containers map[string]*FakeContainer
+
+	mu     sync.Mutex
+	nextID int
+}
+
+// FakeContainer is a container of the fake runtime
+type FakeContainer struct {
+	Spec    ContainerSpec
+	Files   map[string][]byte
+	Started bool
+	Removed bool
+
+	exitc  chan Exit
+	output string
+}
+
+// NewFakeRuntime returns a fake runtime, with a container exiting with the status code and output returned by verify.
+func NewFakeRuntime(verify func(spec ContainerSpec, files map[string][]byte) (int64, string)) *FakeRuntime {
+	return &FakeRuntime{Verify: verify, Containers: make(map[string]*FakeContainer)}
+}
+
+func (f *FakeRuntime) container(id string) (*FakeContainer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.Containers[id]
+	if !ok || c.Removed {
+		return nil, fmt.Errorf("no such container: %v", id)
+	}
+	return c, nil
+}
+
+func (f *FakeRuntime) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	if f.Err != nil {
+		return "", f.Err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	id := fmt.Sprintf("fake-%d", f.nextID)
+	f.Containers[id] = &FakeContainer{Spec: spec, Files: make(map[string][]byte), exitc: make(chan Exit, 1)}
+	return id, nil
+}
+
+func (f *FakeRuntime) Copy(ctx context.Context, id string, files map[string][]byte) error {
+	c, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	for p, b := range files {
+		c.Files[p] = b
+	}
+	return nil
+}
+
+func (f *FakeRuntime) Wait(ctx context.Context, id string) <-chan Exit {
+	c, err := f.container(id)
+	if err != nil {
+		exitc := make(chan Exit, 1)
+		exitc <- Exit{Err: err}
+		return exitc
+	}
+	return c.exitc
+}
+
+func (f *FakeRuntime) Start(ctx context.Context, id string) error {
+	c, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	if c.Started {
+		return fmt.Errorf("container %v is already started", id)
+	}
+	c.Started = true
+
+	var code int64
+	if f.Verify != nil {
+		code, c.output = f.Verify(c.Spec, c.Files)
+	}
+	c.exitc <- Exit{StatusCode: code}
+	return nil
+}
+
+func (f *FakeRuntime) Logs(ctx context.Context, id string) (io.ReadCloser, error) {
+	c, err := f.container(id)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Started {
+		return nil, fmt.Errorf("container %v is not started", id)
+	}
+	return ioutil.NopCloser(strings.NewReader(c.output)), nil
+}
+
+func (f *FakeRuntime) Remove(ctx context.Context, id string) error {
+	c, err := f.container(id)
+	if err != nil {
+		return err
+	}
+	c.Removed = true
+	return nil
+}
@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// VerificationImageDigest is the trusted digest of the default verification image, set at build time.
+var VerificationImageDigest string
+
+// TrustedDigestFunc returns the trusted digest of an image reference, typically from its TUF collection
+type TrustedDigestFunc func(ref string) (digest.Digest, error)
+
+// PinImage returns the verification image pinned by its trusted digest, so that the image that is run
+// cannot be replaced by retagging it. An image referenced by digest is already pinned. Otherwise, its digest is
+// taken from the pinned references ("repository[:tag]@sha256:..."), then from trusted.
+// PinImage fails if the image cannot be pinned.
+func PinImage(image string, pinned []string, trusted TrustedDigestFunc) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("invalid verification image %v: %v", image, err)
+	}
+	if canonical, ok := named.(reference.Canonical); ok {
+		return canonical.String(), nil
+	}
+	named = reference.TagNameOnly(named)
+
+	if VerificationImageDigest != "" {
+		pinned = append(pinned, VerificationImage+"@"+VerificationImageDigest)
+	}
+	for _, p := range pinned {
+		d, ok, err := matchPinned(named, p)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			log.Infof("Using pinned digest %v for verification image %v", d, image)
+			return withDigest(named, d)
+		}
+	}
+
+	if trusted == nil {
+		return "", fmt.Errorf("no trusted digest for verification image %v", image)
+	}
+	d, err := trusted(named.String())
+	if err != nil {
+		return "", fmt.Errorf("cannot get trusted digest for verification image %v: %v", image, err)
+	}
+	if err := d.Validate(); err != nil {
+		return "", fmt.Errorf("invalid trusted digest for verification image %v: %v", image, err)
+	}
+	log.Infof("Using trusted digest %v for verification image %v", d, image)
+	return withDigest(named, d)
+}
+
+// matchPinned returns the digest of a pinned reference, if it references the image.
+// A pinned reference without a tag matches all the tags of the repository.
+func matchPinned(image reference.Named, pinned string) (digest.Digest, bool, error) {
+	ref, err := reference.ParseNormalizedNamed(pinned)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid pinned verification image %v: %v", pinned, err)
+	}
+	canonical, ok := ref.(reference.Canonical)
+	if !ok {
+		return "", false, fmt.Errorf("pinned verification image %v has no digest", pinned)
+	}
+	if ref.Name() != image.Name() {
+		return "", false, nil
+	}
+	if tagged, ok := ref.(reference.Tagged); ok && tagged.Tag() != image.(reference.Tagged).Tag() {
+		return "", false, nil
+	}
+	return canonical.Digest(), true, nil
+}
+
+func withDigest(image reference.Named, d digest.Digest) (string, error) {
+	ref, err := reference.WithDigest(reference.TrimNamed(image), d)
+	if err != nil {
+		return "", err
+	}
+	return ref.String(), nil
+}
@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReadPullOutput reads the JSON messages of an image pull until it completes, and returns
+// the digest reported by the daemon, which is empty if the daemon did not report it.
+func ReadPullOutput(r io.Reader) (digest.Digest, error) {
+	var d digest.Digest
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return d, nil
+			}
+			return "", err
+		}
+		if msg.Error != nil {
+			return "", msg.Error
+		}
+		if strings.HasPrefix(msg.Status, "Digest: ") {
+			parsed, err := digest.Parse(strings.TrimPrefix(msg.Status, "Digest: "))
+			if err != nil {
+				return "", fmt.Errorf("invalid digest in pull output: %v", err)
+			}
+			d = parsed
+		}
+		log.Debug(msg.Status)
+	}
+}
+
+// HasRepoDigest returns whether the repository digests of a local image, as returned by the daemon,
+// contain the digest d for the repository of ref.
+func HasRepoDigest(ref reference.Named, repoDigests []string, d digest.Digest) bool {
+	for _, rd := range repoDigests {
+		n, err := reference.ParseNormalizedNamed(rd)
+		if err != nil {
+			continue
+		}
+		c, ok := n.(reference.Canonical)
+		if ok && c.Name() == ref.Name() && c.Digest() == d {
+			return true
+		}
+	}
+	return false
+}
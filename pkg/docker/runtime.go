@@ -0,0 +1,225 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oklog/ulid"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	Tag               string
+	VerificationImage = "cnabio/signy-in-toto-verifier:" + Tag
+)
+
+const (
+	workingDir    = "/in-toto" // Where we expect to copy in-toto artifacts to
+	maxOutputSize = 1 << 20
+)
+
+// Supported container runtimes
+const (
+	DockerRuntime     = "docker"
+	PodmanRuntime     = "podman"
+	ContainerdRuntime = "containerd"
+)
+
+// ContainerSpec describes the verification container to create
+type ContainerSpec struct {
+	Name       string
+	Image      string
+	WorkingDir string
+	Sandbox    Sandbox
+}
+
+// Exit is the result of waiting for a container
+type Exit struct {
+	StatusCode int64
+	Err        error
+}
+
+// Runtime creates and runs the verification containers.
+// Wait must be called before Start, so that the exit of the container cannot be missed.
+type Runtime interface {
+	// Create creates a container, pulling its image if it is not found locally
+	Create(ctx context.Context, spec ContainerSpec) (string, error)
+	// Copy copies files in the working directory of a container, keyed by their relative path
+	Copy(ctx context.Context, id string, files map[string][]byte) error
+	// Wait returns a channel receiving the exit of a container
+	Wait(ctx context.Context, id string) <-chan Exit
+	// Start starts a container
+	Start(ctx context.Context, id string) error
+	// Logs returns the combined stdout and stderr of a container, until it exits
+	Logs(ctx context.Context, id string) (io.ReadCloser, error)
+	// Remove removes a container and its volumes, even if it is running
+	Remove(ctx context.Context, id string) error
+}
+
+// NewRuntime returns the container runtime with the given name.
+// If endpoint is empty, the default endpoint of the runtime is used.
+func NewRuntime(name, endpoint string) (Runtime, error) {
+	switch name {
+	case DockerRuntime, "":
+		return newDockerRuntime(endpoint)
+	case PodmanRuntime:
+		if endpoint == "" {
+			endpoint = defaultPodmanEndpoint()
+		}
+		return newDockerRuntime(endpoint)
+	case ContainerdRuntime:
+		return newContainerdRuntime(endpoint)
+	default:
+		return nil, fmt.Errorf("unknown container runtime %q", name)
+	}
+}
+
+// ExitError is returned by Run when the verification container exits with a non-zero status code
+type ExitError struct {
+	StatusCode int64
+	// Output is the output of the container
+	Output string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("verification container exited with status code %d", e.StatusCode)
+}
+
+// Run will start a container, copy all In-Toto metadata in /in-toto
+// then run in-toto-verification, within the restrictions of the sandbox.
+// If the verification fails, the returned error is an *ExitError.
+func Run(rt Runtime, verificationImage, verificationDir string, sandbox Sandbox) error {
+	ctx := context.Background()
+	if sandbox.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sandbox.Timeout)
+		defer cancel()
+	}
+
+	id, err := rt.Create(ctx, ContainerSpec{
+		Name:       fmt.Sprintf("intoto-verifications-%s", getULID()),
+		Image:      verificationImage,
+		WorkingDir: workingDir,
+		Sandbox:    sandbox,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The container is removed even if the verification timed out.
+	defer func() {
+		if err := rt.Remove(context.Background(), id); err != nil {
+			log.Warnf("cannot remove container %v: %v", id, err)
+		}
+	}()
+
+	files, err := buildFileMap(verificationDir)
+	if err != nil {
+		return err
+	}
+	for p := range files {
+		log.Infof("copying file %v in container for verification...", p)
+	}
+	if err := rt.Copy(ctx, id, files); err != nil {
+		return fmt.Errorf("cannot copy files in container: %v", err)
+	}
+
+	// Wait for the container before starting it, so that its exit cannot be missed.
+	exitc := rt.Wait(ctx, id)
+
+	if err = rt.Start(ctx, id); err != nil {
+		return fmt.Errorf("cannot start container: %v", err)
+	}
+	reader, err := rt.Logs(ctx, id)
+	if err != nil {
+		return fmt.Errorf("cannot get container logs: %v", err)
+	}
+	defer reader.Close()
+
+	type logs struct {
+		output string
+		err    error
+	}
+	logsc := make(chan logs, 1)
+	go func() {
+		output, err := collectOutput(reader)
+		logsc <- logs{output, err}
+	}()
+
+	exit := <-exitc
+	if exit.Err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("verification container timed out after %v", sandbox.Timeout)
+		}
+		return fmt.Errorf("error in container: %v", exit.Err)
+	}
+
+	// The log stream ends when the container exits.
+	l := <-logsc
+	if l.err != nil {
+		log.Warnf("cannot read all container logs: %v", l.err)
+	}
+	if exit.StatusCode != 0 {
+		return &ExitError{StatusCode: exit.StatusCode, Output: l.output}
+	}
+	return nil
+}
+
+// collectOutput logs each line of the container output, and returns the output.
+// Only the last maxOutputSize bytes of the output are kept.
+func collectOutput(r io.Reader) (string, error) {
+	var output []byte
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log.Info(scanner.Text())
+		output = append(output, scanner.Bytes()...)
+		output = append(output, '\n')
+		if len(output) > maxOutputSize {
+			output = output[len(output)-maxOutputSize:]
+		}
+	}
+	return string(output), scanner.Err()
+}
+
+// buildFileMap reads the verification directory tree, so that links for sublayouts
+// stored in subdirectories are also copied in the container. Paths are relative to the working directory.
+func buildFileMap(verificationDir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+
+	err := filepath.Walk(verificationDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(verificationDir, p)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = b
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func getULID() string {
+	t := time.Unix(1000000, 0)
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	return ulid.MustNew(ulid.Timestamp(t), entropy).String()
+}
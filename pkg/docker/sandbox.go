@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Sandbox restricts what the verification container can do. Inspections run code from the
+// root layout, so by default the container has no network, a read-only root filesystem,
+// no capabilities, and limited resources.
+type Sandbox struct {
+	// Network is the network mode of the container, "none" disabling networking
+	Network string
+	// WritableRootfs allows writing to the root filesystem of the container
+	WritableRootfs bool
+	// CapAdd are the capabilities added back to the container, all others being dropped
+	CapAdd []string
+	// Memory is the memory limit in bytes, 0 meaning no limit
+	Memory int64
+	// CPUs is the number of CPUs the container can use, 0 meaning no limit
+	CPUs float64
+	// PidsLimit is the maximum number of processes in the container, 0 meaning no limit
+	PidsLimit int64
+	// Timeout is the maximum duration of the verification, 0 meaning no timeout
+	Timeout time.Duration
+}
+
+// DefaultSandbox returns the default restrictions of the verification container
+func DefaultSandbox() Sandbox {
+	return Sandbox{
+		Network:   "none",
+		Memory:    512 << 20,
+		CPUs:      1,
+		PidsLimit: 256,
+		Timeout:   10 * time.Minute,
+	}
+}
+
+func (s Sandbox) hostConfig() *container.HostConfig {
+	hc := &container.HostConfig{
+		NetworkMode:    container.NetworkMode(s.Network),
+		ReadonlyRootfs: !s.WritableRootfs,
+		CapDrop:        []string{"ALL"},
+		CapAdd:         s.CapAdd,
+		SecurityOpt:    []string{"no-new-privileges"},
+		// The in-toto verification writes inspection links in the working directory, which is a volume,
+		// and the inspection commands may need a temporary directory.
+		Tmpfs: map[string]string{"/tmp": "rw,noexec,nosuid,size=64m"},
+		Resources: container.Resources{
+			Memory:   s.Memory,
+			NanoCPUs: int64(s.CPUs * 1e9),
+		},
+	}
+	if s.PidsLimit > 0 {
+		limit := s.PidsLimit
+		hc.Resources.PidsLimit = &limit
+	}
+	return hc
+}
@@ -0,0 +1,366 @@
+package intoto
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	log "github.com/sirupsen/logrus"
+	"github.com/theupdateframework/notary/client"
+	"gopkg.in/yaml.v2"
+
+	"github.com/cnabio/signy/pkg/canonicaljson"
+)
+
+const (
+	// StatementType is the type of in-toto attestation statements (ITE-6)
+	StatementType = "https://in-toto.io/Statement/v0.1"
+	// PayloadType is the DSSE payload type of in-toto attestation statements
+	PayloadType = "application/vnd.in-toto+json"
+
+	// SLSAProvenancePredicateType is the predicate type of SLSA provenance attestations
+	SLSAProvenancePredicateType = "https://slsa.dev/provenance/v0.1"
+	// SPDXPredicateType is the predicate type of SPDX SBOM attestations
+	SPDXPredicateType = "https://spdx.dev/Document"
+	// CycloneDXPredicateType is the predicate type of CycloneDX SBOM attestations
+	CycloneDXPredicateType = "https://cyclonedx.org/bom"
+)
+
+// Statement is an in-toto attestation statement, binding a typed predicate to a set of subjects.
+type Statement struct {
+	Type          string          `json:"_type"`
+	Subject       []Subject       `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate,omitempty"`
+}
+
+// Subject is an artifact an attestation statement is about, identified by its digests.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Envelope is a DSSE envelope carrying a signed attestation statement.
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"`
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+// EnvelopeSignature is the signature of a DSSE envelope payload. Sig is base64 encoded.
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// pae is the DSSE pre-authentication encoding of a payload, which is what gets signed.
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// SignStatement signs an attestation statement, and returns it in a DSSE envelope.
+func SignStatement(s *Statement, key in_toto.Key) (*Envelope, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := in_toto.GenerateSignature(pae(PayloadType, payload), key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign attestation: %v", err)
+	}
+	b, err := hex.DecodeString(sig.Sig)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []EnvelopeSignature{{KeyID: sig.KeyID, Sig: base64.StdEncoding.EncodeToString(b)}},
+	}, nil
+}
+
+// statement decodes the attestation statement of the envelope, without verifying it.
+func (e *Envelope) statement() (*Statement, error) {
+	if e.PayloadType != PayloadType {
+		return nil, fmt.Errorf("unsupported attestation payload type %q", e.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode attestation payload: %v", err)
+	}
+	s := &Statement{}
+	if err := json.Unmarshal(payload, s); err != nil {
+		return nil, fmt.Errorf("cannot decode attestation statement: %v", err)
+	}
+	if s.Type != StatementType {
+		return nil, fmt.Errorf("unsupported attestation statement type %q", s.Type)
+	}
+	return s, nil
+}
+
+// verify checks that the envelope is signed by one of the keys, and returns its statement.
+func (e *Envelope) verify(keys map[string]in_toto.Key) (*Statement, error) {
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode attestation payload: %v", err)
+	}
+	signed := pae(e.PayloadType, payload)
+	for _, sig := range e.Signatures {
+		key, ok := keys[sig.KeyID]
+		if !ok {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(sig.Sig)
+		if err != nil {
+			continue
+		}
+		if err := in_toto.VerifySignature(key, in_toto.Signature{KeyID: sig.KeyID, Sig: hex.EncodeToString(b)}, signed); err == nil {
+			return e.statement()
+		}
+	}
+	return nil, fmt.Errorf("attestation is not signed by a trusted key")
+}
+
+// verifySubject checks that the statement is about the artifact with the given SHA256 digest.
+func (s *Statement) verifySubject(sha256Digest string) error {
+	for _, subject := range s.Subject {
+		if subject.Digest["sha256"] == sha256Digest {
+			return nil
+		}
+	}
+	return fmt.Errorf("no subject of the %v attestation matches the digest %v", s.PredicateType, sha256Digest)
+}
+
+// LoadAttestation reads the DSSE envelope of an attestation from a file, and checks that it is about
+// the artifact being signed. If the file contains an unsigned statement instead, the statement is signed
+// with key, and, if it has no subject, the artifact named name becomes its subject.
+func LoadAttestation(path string, key *in_toto.Key, name string, artifact []byte) (*Envelope, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read attestation %v: %v", path, err)
+	}
+	sum := sha256.Sum256(artifact)
+	dgst := hex.EncodeToString(sum[:])
+
+	e := &Envelope{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, fmt.Errorf("cannot decode attestation %v: %v", path, err)
+	}
+	if e.PayloadType == "" {
+		s := &Statement{}
+		if err := json.Unmarshal(b, s); err != nil {
+			return nil, fmt.Errorf("cannot decode attestation statement %v: %v", path, err)
+		}
+		if s.Type != StatementType {
+			return nil, fmt.Errorf("%v is neither a DSSE envelope nor an in-toto statement", path)
+		}
+		if key == nil {
+			return nil, fmt.Errorf("attestation statement %v is not signed, and no attestation key was passed", path)
+		}
+		if len(s.Subject) == 0 {
+			s.Subject = []Subject{{Name: name, Digest: map[string]string{"sha256": dgst}}}
+		}
+		if e, err = SignStatement(s, *key); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := e.statement()
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation %v: %v", path, err)
+	}
+	if err := s.verifySubject(dgst); err != nil {
+		return nil, fmt.Errorf("invalid attestation %v: %v", path, err)
+	}
+	return e, nil
+}
+
+// AddAttestations adds DSSE envelopes to the in-toto metadata of a TUF custom field.
+// custom can be nil, when the target has attestations but no in-toto layout.
+func AddAttestations(custom canonicaljson.RawMessage, envelopes []*Envelope) (canonicaljson.RawMessage, error) {
+	m := &Metadata{}
+	if custom != nil {
+		if err := json.Unmarshal(custom, m); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range envelopes {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		m.Attestations = append(m.Attestations, b)
+	}
+	return canonicaljson.Marshal(m)
+}
+
+// AttestationPolicy is a set of rules on the predicates of the attestations of a target.
+type AttestationPolicy struct {
+	Rules []PredicateRule `yaml:"rules"`
+}
+
+// PredicateRule constrains the attestations with a given predicate type.
+type PredicateRule struct {
+	PredicateType string `yaml:"predicateType"`
+	// Required fails the verification if no attestation has the predicate type
+	Required bool `yaml:"required"`
+	// Match maps dot separated paths in the predicate to their expected value,
+	// for example "builder.id" for the builder of SLSA provenance.
+	Match map[string]string `yaml:"match"`
+}
+
+// LoadAttestationPolicy reads an attestation policy from a YAML file
+func LoadAttestationPolicy(path string) (*AttestationPolicy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read attestation policy %v: %v", path, err)
+	}
+	p := &AttestationPolicy{}
+	if err := yaml.UnmarshalStrict(b, p); err != nil {
+		return nil, fmt.Errorf("cannot decode attestation policy %v: %v", path, err)
+	}
+	for _, r := range p.Rules {
+		if r.PredicateType == "" {
+			return nil, fmt.Errorf("invalid attestation policy %v: rule without a predicate type", path)
+		}
+	}
+	return p, nil
+}
+
+// evaluate checks the policy against verified attestation statements.
+func (p *AttestationPolicy) evaluate(statements []*Statement) error {
+	for _, r := range p.Rules {
+		found := false
+		for _, s := range statements {
+			if s.PredicateType != r.PredicateType {
+				continue
+			}
+			found = true
+			var predicate interface{}
+			if err := json.Unmarshal(s.Predicate, &predicate); err != nil {
+				return fmt.Errorf("cannot decode %v predicate: %v", s.PredicateType, err)
+			}
+			for path, expected := range r.Match {
+				v, ok := lookup(predicate, path)
+				if !ok {
+					return fmt.Errorf("%v predicate has no %v", s.PredicateType, path)
+				}
+				if v != expected {
+					return fmt.Errorf("%v predicate has %v %q, expected %q", s.PredicateType, path, v, expected)
+				}
+			}
+		}
+		if !found && r.Required {
+			return fmt.Errorf("no attestation with predicate type %v", r.PredicateType)
+		}
+	}
+	return nil
+}
+
+// lookup returns the scalar value at a dot separated path in a decoded JSON value.
+// Path elements index objects by key, and arrays by position.
+func lookup(v interface{}, path string) (string, bool) {
+	for _, elem := range strings.Split(path, ".") {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = x[elem]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(elem)
+			if err != nil || i < 0 || i >= len(x) {
+				return "", false
+			}
+			v = x[i]
+		default:
+			return "", false
+		}
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+// VerifyAttestations verifies the attestations of a target: their DSSE signatures against the keys read
+// from keyPaths, their subject against the target digest, and the policy, if any, against their predicates.
+// When no keys are passed, the attestations must be signed by a functionary of the root layout.
+func VerifyAttestations(ctx context.Context, target *client.TargetWithRole, keyPaths []string, policy *AttestationPolicy) error {
+	if target.Custom == nil {
+		return fmt.Errorf("target has no attestations")
+	}
+	m, err := loadMetadata(ctx, *target.Custom)
+	if err != nil {
+		return err
+	}
+	if len(m.Attestations) == 0 {
+		return fmt.Errorf("target has no attestations")
+	}
+
+	keys, err := attestationKeys(m, keyPaths)
+	if err != nil {
+		return err
+	}
+
+	dgst := hex.EncodeToString(target.Hashes["sha256"])
+	var statements []*Statement
+	for i, b := range m.Attestations {
+		e := &Envelope{}
+		if err := json.Unmarshal(b, e); err != nil {
+			return fmt.Errorf("cannot decode attestation %d: %v", i, err)
+		}
+		s, err := e.verify(keys)
+		if err != nil {
+			return fmt.Errorf("invalid attestation %d: %v", i, err)
+		}
+		if err := s.verifySubject(dgst); err != nil {
+			return fmt.Errorf("invalid attestation %d: %v", i, err)
+		}
+		log.Infof("Verified %v attestation", s.PredicateType)
+		statements = append(statements, s)
+	}
+
+	if policy != nil {
+		if err := policy.evaluate(statements); err != nil {
+			return fmt.Errorf("attestation policy failed: %v", err)
+		}
+		log.Infof("The attestations satisfy the policy")
+	}
+	return nil
+}
+
+// attestationKeys returns the keys trusted to sign attestations, indexed by key ID.
+func attestationKeys(m *Metadata, keyPaths []string) (map[string]in_toto.Key, error) {
+	keys := make(map[string]in_toto.Key)
+	for _, p := range keyPaths {
+		k, err := LoadKey(p, DefaultKeyScheme)
+		if err != nil {
+			return nil, err
+		}
+		keys[k.KeyID] = k
+	}
+	if len(keys) > 0 {
+		return keys, nil
+	}
+
+	if len(m.Layout) == 0 {
+		return nil, fmt.Errorf("no attestation keys, and no in-toto layout to trust functionary keys from")
+	}
+	rootLayout, err := decodeLayout(m.Layout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := verifyLayoutThreshold(rootLayout, m.layoutKeys(), m.Threshold); err != nil {
+		return nil, fmt.Errorf("invalid root layout signature: %v", err)
+	}
+	return rootLayout.Signed.(in_toto.Layout).Keys, nil
+}
@@ -0,0 +1,80 @@
+package intoto
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/tuf/data"
+
+	"github.com/cnabio/signy/pkg/canonicaljson"
+)
+
+func TestVerifyAttestations(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "in-toto-attestation")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+	key := generateTestKey(t, dir)
+
+	artifact := []byte(`{"name":"helloworld"}`)
+	statementPath := filepath.Join(dir, "provenance.json")
+	is.NoError(ioutil.WriteFile(statementPath, []byte(`{
+		"_type": "https://in-toto.io/Statement/v0.1",
+		"predicateType": "https://slsa.dev/provenance/v0.1",
+		"predicate": {"builder": {"id": "https://ci.example.com/builder@v1"}, "materials": [{"uri": "git+https://example.com/repo"}]}
+	}`), 0644))
+
+	// the statement is signed, and the artifact becomes its subject.
+	e, err := LoadAttestation(statementPath, &key, "localhost:5000/thin-bundle:v1", artifact)
+	is.NoError(err)
+	// a DSSE envelope about another artifact is refused.
+	envelopePath := filepath.Join(dir, "provenance.dsse.json")
+	b, err := json.Marshal(e)
+	is.NoError(err)
+	is.NoError(ioutil.WriteFile(envelopePath, b, 0644))
+	_, err = LoadAttestation(envelopePath, nil, "", []byte("another artifact"))
+	is.Error(err)
+
+	custom, err := AddAttestations(nil, []*Envelope{e})
+	is.NoError(err)
+	raw := canonicaljson.RawMessage(custom)
+	sum := sha256.Sum256(artifact)
+	target := &client.TargetWithRole{
+		Target: client.Target{Hashes: data.Hashes{"sha256": sum[:]}, Custom: &raw},
+	}
+	keyPaths := []string{filepath.Join(dir, "functionary.pem")}
+
+	policy := &AttestationPolicy{Rules: []PredicateRule{{
+		PredicateType: SLSAProvenancePredicateType,
+		Required:      true,
+		Match:         map[string]string{"builder.id": "https://ci.example.com/builder@v1", "materials.0.uri": "git+https://example.com/repo"},
+	}}}
+	is.NoError(VerifyAttestations(context.Background(), target, keyPaths, policy))
+
+	policy.Rules[0].Match["builder.id"] = "https://evil.example.com"
+	is.Error(VerifyAttestations(context.Background(), target, keyPaths, policy))
+	policy = &AttestationPolicy{Rules: []PredicateRule{{PredicateType: SPDXPredicateType, Required: true}}}
+	is.Error(VerifyAttestations(context.Background(), target, keyPaths, policy))
+
+	// the attestation must be signed by a trusted key.
+	otherDir := filepath.Join(dir, "other")
+	is.NoError(os.Mkdir(otherDir, 0755))
+	generateTestKey(t, otherDir)
+	is.Error(VerifyAttestations(context.Background(), target, []string{filepath.Join(otherDir, "functionary.pem")}, nil))
+
+	// and be about the target.
+	other := sha256.Sum256([]byte("another artifact"))
+	target.Hashes["sha256"] = other[:]
+	err = VerifyAttestations(context.Background(), target, keyPaths, nil)
+	is.Error(err)
+	is.Contains(err.Error(), hex.EncodeToString(other[:]))
+}
@@ -0,0 +1,77 @@
+package intoto
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultExpiryWarning is how long before the root layout expires verification starts warning about it
+const DefaultExpiryWarning = 30 * 24 * time.Hour
+
+// LayoutExpiry returns the expiry date of a layout
+func LayoutExpiry(layout in_toto.Layout) (time.Time, error) {
+	expires, err := time.Parse(in_toto.ISO8601DateSchema, layout.Expires)
+	if err != nil {
+		return expires, fmt.Errorf("cannot parse layout expiry %q: %v", layout.Expires, err)
+	}
+	return expires, nil
+}
+
+// CheckLayoutExpiry returns an error if the layout has expired, or if it expires
+// in less than minValidity from now.
+func CheckLayoutExpiry(layout in_toto.Layout, now time.Time, minValidity time.Duration) error {
+	expires, err := LayoutExpiry(layout)
+	if err != nil {
+		return err
+	}
+	if !now.Before(expires) {
+		return fmt.Errorf("layout has expired on %v", expires)
+	}
+	if expires.Sub(now) < minValidity {
+		return fmt.Errorf("layout expires on %v, in less than %v", expires, minValidity)
+	}
+	return nil
+}
+
+// CheckLayoutExpiryFromPath checks the expiry of a layout file
+func CheckLayoutExpiryFromPath(p string, minValidity time.Duration) error {
+	l, err := getLayout(p)
+	if err != nil {
+		return err
+	}
+	return CheckLayoutExpiry(*l, time.Now(), minValidity)
+}
+
+// MetadataLayoutExpiry returns the expiry date of the root layout stored in the TUF custom field.
+// It returns false if the custom field has no in-toto layout, such as for targets with only
+// attestations, or bundle or image metadata.
+func MetadataLayoutExpiry(ctx context.Context, custom []byte) (time.Time, bool, error) {
+	m, err := loadMetadata(ctx, custom)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if len(m.Layout) == 0 {
+		return time.Time{}, false, nil
+	}
+	rootLayout, err := decodeLayout(m.Layout)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	expires, err := LayoutExpiry(rootLayout.Signed.(in_toto.Layout))
+	return expires, err == nil, err
+}
+
+// checkLayoutFreshness refuses an expired layout, and warns if it expires within window.
+func checkLayoutFreshness(layout in_toto.Layout, now time.Time, window time.Duration) error {
+	if err := CheckLayoutExpiry(layout, now, 0); err != nil {
+		return err
+	}
+	if err := CheckLayoutExpiry(layout, now, window); err != nil {
+		log.Warnf("The in-toto root layout must be renewed soon: %v", err)
+	}
+	return nil
+}
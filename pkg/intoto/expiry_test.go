@@ -0,0 +1,52 @@
+package intoto
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLayoutExpiry(t *testing.T) {
+	is := assert.New(t)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	layout := in_toto.Layout{Type: "layout", Expires: "2020-01-31T00:00:00Z"}
+
+	is.NoError(CheckLayoutExpiry(layout, now, 0))
+	is.NoError(CheckLayoutExpiry(layout, now, 7*24*time.Hour))
+	is.Error(CheckLayoutExpiry(layout, now, 60*24*time.Hour))
+	is.Error(CheckLayoutExpiry(layout, now.AddDate(0, 1, 0), 0))
+
+	// a layout close to expiry is still accepted on verification.
+	is.NoError(checkLayoutFreshness(layout, now, DefaultExpiryWarning))
+	is.Error(checkLayoutFreshness(layout, now.AddDate(0, 1, 0), DefaultExpiryWarning))
+
+	layout.Expires = "tomorrow"
+	is.Error(CheckLayoutExpiry(layout, now, 0))
+}
+
+func TestMetadataLayoutExpiry(t *testing.T) {
+	is := assert.New(t)
+
+	custom, err := GetMetadataRawMessage(filepath.Join(testDir, "root.layout"), testDir, []string{filepath.Join(testDir, "alice.pub")}, 0)
+	is.NoError(err)
+	expires, ok, err := MetadataLayoutExpiry(context.Background(), custom)
+	is.NoError(err)
+	is.True(ok)
+	is.False(expires.IsZero())
+
+	// targets signed without in-toto metadata can still have custom metadata.
+	for _, custom := range []string{
+		`{"bundle":{"digest":"sha256:c7e92bd51f059d60b15ad456edf194648997d739f60799b37e08edafd88a81b5","mediaType":"application/vnd.oci.image.index.v1+json","size":1157}}`,
+		`{"image":{"kind":"manifest","mediaType":"application/vnd.docker.distribution.manifest.v2+json"}}`,
+		`{"attestations":["e30="]}`,
+	} {
+		_, ok, err := MetadataLayoutExpiry(context.Background(), []byte(custom))
+		is.NoError(err)
+		is.False(ok)
+	}
+}
@@ -0,0 +1,252 @@
+package intoto
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultLayoutExpiry is the validity of a layout whose spec does not set an expiry
+const DefaultLayoutExpiry = 30 * 24 * time.Hour
+
+// LayoutSpec is the declarative description of a root layout.
+//
+// Example:
+//
+//	expires: 8760h
+//	keys:
+//	  alice: alice.pub
+//	  bob: bob.pub
+//	steps:
+//	- name: package
+//	  functionaries: [bob]
+//	  expected_command: [tar, zcvf, foo.tar.gz, foo.py]
+//	  expected_materials:
+//	  - MATCH foo.py WITH PRODUCTS FROM write-code
+//	  - DISALLOW *
+//	  expected_products:
+//	  - CREATE foo.tar.gz
+//	inspections:
+//	- name: untar
+//	  run: [tar, xfz, foo.tar.gz]
+//	  expected_materials:
+//	  - MATCH foo.tar.gz WITH PRODUCTS FROM package
+type LayoutSpec struct {
+	// Expires is either a duration from now, or an RFC 3339 date
+	Expires string `yaml:"expires"`
+	Readme  string `yaml:"readme"`
+	// Keys maps the names of the functionaries to the paths of their public keys,
+	// relative to the spec file
+	Keys        map[string]string `yaml:"keys"`
+	Steps       []StepSpec        `yaml:"steps"`
+	Inspections []InspectionSpec  `yaml:"inspections"`
+}
+
+// StepSpec describes a step of a layout
+type StepSpec struct {
+	Name              string         `yaml:"name"`
+	Functionaries     []string       `yaml:"functionaries"`
+	Threshold         int            `yaml:"threshold"`
+	ExpectedCommand   []string       `yaml:"expected_command"`
+	ExpectedMaterials []ArtifactRule `yaml:"expected_materials"`
+	ExpectedProducts  []ArtifactRule `yaml:"expected_products"`
+}
+
+// InspectionSpec describes an inspection of a layout
+type InspectionSpec struct {
+	Name              string         `yaml:"name"`
+	Run               []string       `yaml:"run"`
+	ExpectedMaterials []ArtifactRule `yaml:"expected_materials"`
+	ExpectedProducts  []ArtifactRule `yaml:"expected_products"`
+}
+
+// ArtifactRule is an in-toto artifact rule, written either as a list or as a
+// whitespace separated string, such as "MATCH foo.py WITH PRODUCTS FROM write-code".
+type ArtifactRule []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (r *ArtifactRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*r = strings.Fields(s)
+		return nil
+	}
+	var l []string
+	if err := unmarshal(&l); err != nil {
+		return fmt.Errorf("artifact rule must be a string or a list of strings")
+	}
+	*r = l
+	return nil
+}
+
+// CreateLayout builds a root layout from the spec file, validates it, signs it with
+// the layout owner key and writes it to out.
+func CreateLayout(specPath string, key in_toto.Key, out string) error {
+	b, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return fmt.Errorf("cannot read layout spec %v: %v", specPath, err)
+	}
+	spec := &LayoutSpec{}
+	if err := yaml.UnmarshalStrict(b, spec); err != nil {
+		return fmt.Errorf("cannot decode layout spec %v: %v", specPath, err)
+	}
+
+	layout, err := NewLayout(spec, filepath.Dir(specPath), time.Now())
+	if err != nil {
+		return err
+	}
+	if err := ValidateLayout(*layout); err != nil {
+		return fmt.Errorf("invalid layout: %v", err)
+	}
+
+	mb := in_toto.Metablock{Signed: *layout, Signatures: []in_toto.Signature{}}
+	if err := mb.Sign(key); err != nil {
+		return fmt.Errorf("cannot sign layout: %v", err)
+	}
+	if err := mb.Dump(out); err != nil {
+		return fmt.Errorf("cannot write layout %v: %v", out, err)
+	}
+	log.Infof("Wrote layout %v signed with key %v", out, key.KeyID)
+	return nil
+}
+
+// SignLayout adds the signature of an additional layout owner to the layout at
+// layoutPath, and writes it to out. An existing signature from the same key is replaced.
+func SignLayout(layoutPath string, key in_toto.Key, out string) error {
+	var mb in_toto.Metablock
+	if err := mb.Load(layoutPath); err != nil {
+		return fmt.Errorf("cannot load layout from %v: %v", layoutPath, err)
+	}
+	layout, ok := mb.Signed.(in_toto.Layout)
+	if !ok {
+		return fmt.Errorf("%v is not a layout", layoutPath)
+	}
+	if err := ValidateLayout(layout); err != nil {
+		return fmt.Errorf("invalid layout: %v", err)
+	}
+
+	signatures := []in_toto.Signature{}
+	for _, s := range mb.Signatures {
+		if s.KeyID != key.KeyID {
+			signatures = append(signatures, s)
+		}
+	}
+	mb.Signatures = signatures
+	if err := mb.Sign(key); err != nil {
+		return fmt.Errorf("cannot sign layout: %v", err)
+	}
+	if err := mb.Dump(out); err != nil {
+		return fmt.Errorf("cannot write layout %v: %v", out, err)
+	}
+	log.Infof("Wrote layout %v with %v signature(s)", out, len(mb.Signatures))
+	return nil
+}
+
+// NewLayout builds an unsigned layout from a spec. Paths to the functionary keys
+// are relative to baseDir, and a duration expiry is relative to now.
+func NewLayout(spec *LayoutSpec, baseDir string, now time.Time) (*in_toto.Layout, error) {
+	expires, err := layoutExpiry(spec.Expires, now)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := &in_toto.Layout{
+		Type:    "layout",
+		Steps:   []in_toto.Step{},
+		Inspect: []in_toto.Inspection{},
+		Keys:    map[string]in_toto.Key{},
+		Expires: expires,
+		Readme:  spec.Readme,
+	}
+
+	keyIDs := make(map[string]string)
+	for name, p := range spec.Keys {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(baseDir, p)
+		}
+		key, err := LoadKey(p, DefaultKeyScheme)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load key of functionary %v: %v", name, err)
+		}
+		// only the public part of functionary keys belongs in a layout
+		key.KeyVal.Private = ""
+		layout.Keys[key.KeyID] = key
+		keyIDs[name] = key.KeyID
+	}
+
+	for _, s := range spec.Steps {
+		step := in_toto.Step{
+			Type:            "step",
+			PubKeys:         []string{},
+			ExpectedCommand: s.ExpectedCommand,
+			Threshold:       s.Threshold,
+			SupplyChainItem: in_toto.SupplyChainItem{
+				Name:              s.Name,
+				ExpectedMaterials: rules(s.ExpectedMaterials),
+				ExpectedProducts:  rules(s.ExpectedProducts),
+			},
+		}
+		if step.ExpectedCommand == nil {
+			step.ExpectedCommand = []string{}
+		}
+		if step.Threshold == 0 {
+			step.Threshold = 1
+		}
+		for _, f := range s.Functionaries {
+			keyID, ok := keyIDs[f]
+			if !ok {
+				return nil, fmt.Errorf("unknown functionary %v in step %v", f, s.Name)
+			}
+			step.PubKeys = append(step.PubKeys, keyID)
+		}
+		if step.Threshold > len(step.PubKeys) {
+			return nil, fmt.Errorf("threshold of step %v is %v, but it only has %v functionaries", s.Name, step.Threshold, len(step.PubKeys))
+		}
+		layout.Steps = append(layout.Steps, step)
+	}
+
+	for _, i := range spec.Inspections {
+		if len(i.Run) == 0 {
+			return nil, fmt.Errorf("inspection %v has no command to run", i.Name)
+		}
+		layout.Inspect = append(layout.Inspect, in_toto.Inspection{
+			Type: "inspection",
+			Run:  i.Run,
+			SupplyChainItem: in_toto.SupplyChainItem{
+				Name:              i.Name,
+				ExpectedMaterials: rules(i.ExpectedMaterials),
+				ExpectedProducts:  rules(i.ExpectedProducts),
+			},
+		})
+	}
+
+	return layout, nil
+}
+
+func layoutExpiry(expires string, now time.Time) (string, error) {
+	if expires == "" {
+		return now.Add(DefaultLayoutExpiry).UTC().Format(in_toto.ISO8601DateSchema), nil
+	}
+	if d, err := time.ParseDuration(expires); err == nil {
+		return now.Add(d).UTC().Format(in_toto.ISO8601DateSchema), nil
+	}
+	t, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return "", fmt.Errorf("expires must be a duration or an RFC 3339 date: %v", expires)
+	}
+	return t.UTC().Format(in_toto.ISO8601DateSchema), nil
+}
+
+func rules(r []ArtifactRule) [][]string {
+	res := [][]string{}
+	for _, rule := range r {
+		res = append(res, rule)
+	}
+	return res
+}
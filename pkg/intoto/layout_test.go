@@ -0,0 +1,118 @@
+package intoto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/stretchr/testify/assert"
+)
+
+const testLayoutSpec = `
+expires: 8760h
+keys:
+  alice: alice.pub
+steps:
+- name: package
+  functionaries: [alice]
+  expected_command: [tar, zcvf, foo.tar.gz, foo.py]
+  expected_materials:
+  - DISALLOW *
+  expected_products:
+  - [CREATE, foo.tar.gz]
+inspections:
+- name: untar
+  run: [tar, xfz, foo.tar.gz]
+  expected_materials:
+  - MATCH foo.tar.gz WITH PRODUCTS FROM package
+`
+
+func TestCreateAndSignLayout(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "in-toto-layout")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	pub, err := ioutil.ReadFile(filepath.Join(testDir, "alice.pub"))
+	is.NoError(err)
+	is.NoError(ioutil.WriteFile(filepath.Join(dir, "alice.pub"), pub, 0644))
+	specPath := filepath.Join(dir, "layout.yaml")
+	is.NoError(ioutil.WriteFile(specPath, []byte(testLayoutSpec), 0644))
+
+	ownerDir := filepath.Join(dir, "owner")
+	is.NoError(os.Mkdir(ownerDir, 0755))
+	owner := generateTestKey(t, ownerDir)
+	layoutPath := filepath.Join(dir, "root.layout")
+	is.NoError(CreateLayout(specPath, owner, layoutPath))
+	is.NoError(ValidateFromPath(layoutPath))
+
+	var mb in_toto.Metablock
+	is.NoError(mb.Load(layoutPath))
+	is.NoError(mb.VerifySignature(owner))
+	layout := mb.Signed.(in_toto.Layout)
+	is.Equal([][]string{{"MATCH", "foo.tar.gz", "WITH", "PRODUCTS", "FROM", "package"}}, layout.Inspect[0].ExpectedMaterials)
+	is.Equal(1, layout.Steps[0].Threshold)
+	is.Len(layout.Keys, 1)
+	for _, k := range layout.Keys {
+		is.Empty(k.KeyVal.Private)
+	}
+
+	secondDir := filepath.Join(dir, "second")
+	is.NoError(os.Mkdir(secondDir, 0755))
+	second := generateTestKey(t, secondDir)
+	is.NoError(SignLayout(layoutPath, second, layoutPath))
+
+	mb = in_toto.Metablock{}
+	is.NoError(mb.Load(layoutPath))
+	is.Len(mb.Signatures, 2)
+	is.NoError(in_toto.VerifyLayoutSignatures(mb, map[string]in_toto.Key{owner.KeyID: owner, second.KeyID: second}))
+}
+
+func TestVerifyLayoutThreshold(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "in-toto-layout")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	pub, err := ioutil.ReadFile(filepath.Join(testDir, "alice.pub"))
+	is.NoError(err)
+	is.NoError(ioutil.WriteFile(filepath.Join(dir, "alice.pub"), pub, 0644))
+	specPath := filepath.Join(dir, "layout.yaml")
+	is.NoError(ioutil.WriteFile(specPath, []byte(testLayoutSpec), 0644))
+
+	var owners []in_toto.Key
+	var keys [][]byte
+	for _, name := range []string{"alice", "bob", "carol"} {
+		ownerDir := filepath.Join(dir, name)
+		is.NoError(os.Mkdir(ownerDir, 0755))
+		owner := generateTestKey(t, ownerDir)
+		owners = append(owners, owner)
+		keys = append(keys, []byte(owner.KeyVal.Public))
+	}
+
+	layoutPath := filepath.Join(dir, "root.layout")
+	is.NoError(CreateLayout(specPath, owners[0], layoutPath))
+	is.NoError(SignLayout(layoutPath, owners[1], layoutPath))
+
+	b, err := ioutil.ReadFile(layoutPath)
+	is.NoError(err)
+	rootLayout, err := decodeLayout(b)
+	is.NoError(err)
+
+	signers, err := verifyLayoutThreshold(rootLayout, keys, 2)
+	is.NoError(err)
+	is.Equal(keys[:2], signers)
+
+	_, err = verifyLayoutThreshold(rootLayout, keys, 3)
+	is.Error(err)
+	// without a threshold, all layout owners must sign.
+	_, err = verifyLayoutThreshold(rootLayout, keys, 0)
+	is.Error(err)
+	// the same owner key cannot be counted twice.
+	_, err = verifyLayoutThreshold(rootLayout, [][]byte{keys[0], keys[0], keys[2]}, 2)
+	is.Error(err)
+}
@@ -1,11 +1,16 @@
 package intoto
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/in-toto/in-toto-golang/in_toto"
+	log "github.com/sirupsen/logrus"
+
 	"github.com/cnabio/signy/pkg/canonicaljson"
 )
 
@@ -13,9 +18,24 @@ import (
 // All fields are represented as []byte in order to be stored in the Custom field for TUF metadata.
 type Metadata struct {
 	// TODO: remove this once the TUF targets key is used to sign the root layout
-	Key    []byte            `json:"key"`
-	Layout []byte            `json:"layout"`
-	Links  map[string][]byte `json:"links"`
+	Key []byte `json:"key"`
+	// Keys are the public keys of all the layout owners, Key being the first one.
+	Keys [][]byte `json:"keys,omitempty"`
+	// Threshold is the number of layout owners that must sign the root layout.
+	// When not set, all layout owners must sign it.
+	Threshold int `json:"threshold,omitempty"`
+
+	Layout []byte `json:"layout"`
+	// Links maps the slash separated path of each link, relative to the links directory,
+	// to its content. Links for sublayouts are stored in <step>.<keyid-prefix>/ subdirectories.
+	Links map[string][]byte `json:"links"`
+
+	// Attestations are the DSSE envelopes of the in-toto attestation statements about the target.
+	Attestations [][]byte `json:"attestations,omitempty"`
+
+	// External points to the metadata when it is stored out of band, see StoreExternal.
+	// All other fields are then empty.
+	External *ExternalMetadata `json:"external,omitempty"`
 }
 
 // WriteMetadataFiles writes the content of a metadata object into files in a directory
@@ -32,30 +52,89 @@ func WriteMetadataFiles(m *Metadata, dir string) error {
 	}
 
 	//FIXME: no need to actually write filenames.
-	err = ioutil.WriteFile(filepath.Join(abs, "root.layout.pub"), m.Key, ReadOnlyMask)
-	if err != nil {
-		return err
+	keys := m.layoutKeys()
+	for i, k := range keys {
+		name := "root.layout.pub"
+		if len(keys) > 1 {
+			name = fmt.Sprintf("root.layout.%d.pub", i)
+		}
+		err = ioutil.WriteFile(filepath.Join(abs, name), k, ReadOnlyMask)
+		if err != nil {
+			return err
+		}
 	}
 
 	for n, c := range m.Links {
-		err = ioutil.WriteFile(filepath.Join(abs, n), c, ReadOnlyMask)
+		p, err := linkPath(abs, n)
 		if err != nil {
 			return err
 		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(p, c, ReadOnlyMask)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// linkPath returns the path of a link inside dir, given its slash separated relative name.
+// Names that would escape dir are rejected, since they come from the TUF custom object.
+func linkPath(dir, name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid link name %v", name)
 	}
+	return filepath.Join(dir, rel), nil
+}
 
+// layoutKeys returns the public keys of the layout owners.
+func (m *Metadata) layoutKeys() [][]byte {
+	if len(m.Keys) > 0 {
+		return m.Keys
+	}
+	if len(m.Key) > 0 {
+		return [][]byte{m.Key}
+	}
 	return nil
 }
 
 // GetMetadataRawMessage takes In-Toto metadata and returns a canonical RawMessage
 // that can be stored in the TUF targets custom field.
+// threshold is the number of layout owners, out of layoutKeys, that must sign the root layout,
+// 0 meaning all of them.
 //
 // TODO: layout signing key should not be passed by the library.
 // Layouts should be signed with the targets key used to sign the TUF collection.
-func GetMetadataRawMessage(layout string, linkDir string, layoutKey string) (canonicaljson.RawMessage, error) {
-	k, err := ioutil.ReadFile(layoutKey)
-	if err != nil {
-		return nil, fmt.Errorf("cannot get canonical JSON from file %v: %v", layoutKey, err)
+func GetMetadataRawMessage(layout string, linkDir string, layoutKeys []string, threshold int) (canonicaljson.RawMessage, error) {
+	if len(layoutKeys) == 0 {
+		return nil, fmt.Errorf("no root layout public key")
+	}
+
+	// the same key may be given more than once, possibly from different files,
+	// and must only count once towards the threshold.
+	var keys [][]byte
+	keyIDs := make(map[string]bool)
+	for _, layoutKey := range layoutKeys {
+		k, err := ioutil.ReadFile(layoutKey)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get canonical JSON from file %v: %v", layoutKey, err)
+		}
+		var key in_toto.Key
+		if err := key.LoadKeyReader(bytes.NewReader(k), DefaultKeyScheme, keyIDHashAlgorithms); err != nil {
+			return nil, fmt.Errorf("cannot load root layout public key %v: %v", layoutKey, err)
+		}
+		if keyIDs[key.KeyID] {
+			continue
+		}
+		keyIDs[key.KeyID] = true
+		keys = append(keys, k)
+	}
+	if threshold < 0 || threshold > len(keys) {
+		return nil, fmt.Errorf("invalid root layout threshold %v for %v distinct key(s)", threshold, len(keys))
 	}
 
 	l, err := ioutil.ReadFile(layout)
@@ -63,30 +142,20 @@ func GetMetadataRawMessage(layout string, linkDir string, layoutKey string) (can
 		return nil, fmt.Errorf("cannot get canonical JSON from file %v: %v", layout, err)
 	}
 
-	links := make(map[string][]byte)
-	files, err := ioutil.ReadDir(linkDir)
+	links, err := readLinks(linkDir, layout)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read links directory %v: %v", linkDir, err)
-	}
-	for _, f := range files {
-		// TODO - Radu M
-		//
-		// robust check if file is actually a link
-		if !strings.Contains(f.Name(), ".link") {
-			continue
-		}
-		b, err := ioutil.ReadFile(filepath.Join(linkDir, f.Name()))
-		if err != nil {
-			return nil, fmt.Errorf("cannot get canonical JSON from file %v: %v", f.Name(), err)
-		}
-		links[f.Name()] = b
+		return nil, err
 	}
 
 	m := &Metadata{
-		Key:    k,
+		Key:    keys[0],
 		Layout: l,
 		Links:  links,
 	}
+	if len(keys) > 1 {
+		m.Keys = keys
+		m.Threshold = threshold
+	}
 
 	raw, err := canonicaljson.Marshal(m)
 	if err != nil {
@@ -95,3 +164,93 @@ func GetMetadataRawMessage(layout string, linkDir string, layoutKey string) (can
 
 	return raw, nil
 }
+
+// readLinks walks the links directory tree and returns the content of every signed link
+// (or sublayout), keyed by its slash separated path relative to linkDir.
+// The root layout is skipped in case it is stored in the links directory.
+func readLinks(linkDir, layout string) (map[string][]byte, error) {
+	root, err := filepath.Abs(linkDir)
+	if err != nil {
+		return nil, err
+	}
+	layoutPath, err := filepath.Abs(layout)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make(map[string][]byte)
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("cannot read links directory %v: %v", linkDir, err)
+		}
+		// unfinished links are left over by an interrupted recording, see RecordStart.
+		if !info.Mode().IsRegular() || p == layoutPath || strings.HasSuffix(p, ".link-unfinished") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		ok, err := isSignedLink(p)
+		if err != nil {
+			return fmt.Errorf("invalid link %v: %v", rel, err)
+		}
+		if !ok {
+			log.Debugf("Skipping %v, not a signed in-toto link", rel)
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("cannot get canonical JSON from file %v: %v", rel, err)
+		}
+		links[filepath.ToSlash(rel)] = b
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// isSignedLink checks whether the file at path is a signed in-toto link, or a sublayout
+// stored under a link name. Files that are not in-toto metadata are not links, unless
+// they are named like one, in which case they are reported as invalid.
+func isSignedLink(path string) (bool, error) {
+	named := strings.HasSuffix(path, ".link")
+
+	var mb in_toto.Metablock
+	if err := mb.Load(path); err != nil {
+		if named {
+			return false, err
+		}
+		return false, nil
+	}
+
+	switch mb.Signed.(type) {
+	case in_toto.Link:
+	case in_toto.Layout:
+		// a layout is only part of the supply chain if it is a sublayout,
+		// which in-toto expects to find under the link name of its step.
+		if !named {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+
+	if len(mb.Signatures) == 0 {
+		// unsigned links, such as the ones recorded by inspections, are not supply chain metadata.
+		if named {
+			log.Warnf("Skipping unsigned link %v", path)
+		}
+		return false, nil
+	}
+
+	if err := in_toto.ValidateMetablock(mb); err != nil {
+		return false, err
+	}
+	return true, nil
+}
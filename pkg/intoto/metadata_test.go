@@ -0,0 +1,97 @@
+package intoto
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMetadataRawMessageNested(t *testing.T) {
+	is := assert.New(t)
+
+	linkDir, err := ioutil.TempDir("", "links")
+	is.NoError(err)
+	defer os.RemoveAll(linkDir)
+
+	// the sublayout links are stored in a <step>.<keyid-prefix> subdirectory.
+	sublayoutDir := filepath.Join(linkDir, "package.2f89b927")
+	is.NoError(os.Mkdir(sublayoutDir, 0755))
+	is.NoError(copy(testDir, linkDir))
+	is.NoError(copy(testDir, sublayoutDir))
+
+	raw, err := GetMetadataRawMessage(filepath.Join(linkDir, "root.layout"), linkDir, []string{filepath.Join(testDir, "alice.pub")}, 0)
+	is.NoError(err)
+
+	m := &Metadata{}
+	is.NoError(json.Unmarshal(raw, m))
+	is.Contains(m.Links, "clone.776a00e2.link")
+	is.Contains(m.Links, "package.2f89b927/clone.776a00e2.link")
+	// unsigned inspection links, the root layout and other files are not stored.
+	is.NotContains(m.Links, "untar.link")
+	is.NotContains(m.Links, "root.layout")
+	is.NotContains(m.Links, "alice.pub")
+	is.NotContains(m.Links, "package.2f89b927/root.layout")
+
+	dir, err := ioutil.TempDir("", "in-toto")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	is.NoError(WriteMetadataFiles(m, dir))
+	is.FileExists(filepath.Join(dir, "root.layout"))
+	is.FileExists(filepath.Join(dir, "package.2f89b927", "clone.776a00e2.link"))
+}
+
+func TestGetMetadataRawMessageMalformedLink(t *testing.T) {
+	is := assert.New(t)
+
+	linkDir, err := ioutil.TempDir("", "links")
+	is.NoError(err)
+	defer os.RemoveAll(linkDir)
+
+	is.NoError(ioutil.WriteFile(filepath.Join(linkDir, "clone.776a00e2.link"), []byte("not a link"), 0644))
+
+	_, err = GetMetadataRawMessage(filepath.Join(testDir, "root.layout"), linkDir, []string{filepath.Join(testDir, "alice.pub")}, 0)
+	is.Error(err)
+}
+
+func TestGetMetadataRawMessageDuplicateKeys(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "in-toto")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// the same key given twice, from different files, counts once towards the threshold.
+	alice := filepath.Join(testDir, "alice.pub")
+	b, err := ioutil.ReadFile(alice)
+	is.NoError(err)
+	aliceCopy := filepath.Join(dir, "alice-copy.pub")
+	is.NoError(ioutil.WriteFile(aliceCopy, b, 0644))
+	layoutKeys := []string{alice, aliceCopy}
+
+	_, err = GetMetadataRawMessage(filepath.Join(testDir, "root.layout"), testDir, layoutKeys, 2)
+	is.EqualError(err, "invalid root layout threshold 2 for 1 distinct key(s)")
+
+	raw, err := GetMetadataRawMessage(filepath.Join(testDir, "root.layout"), testDir, layoutKeys, 1)
+	is.NoError(err)
+	m := &Metadata{}
+	is.NoError(json.Unmarshal(raw, m))
+	is.Equal(b, m.Key)
+	is.Empty(m.Keys)
+	is.Zero(m.Threshold)
+}
+
+func TestWriteMetadataFilesInvalidLinkName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in-toto")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m := &Metadata{
+		Links: map[string][]byte{"../escape.link": []byte("{}")},
+	}
+	assert.Error(t, WriteMetadataFiles(m, dir))
+}
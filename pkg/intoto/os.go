@@ -1,6 +1,8 @@
 package intoto
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
@@ -43,45 +45,148 @@ func getRootLayoutPath(verificationDir string) (string, error) {
 	return filenames[0], nil
 }
 
-// Verify performs the in-toto validation steps
-func verifyOnOS(verificationDir string) error {
+// verifyOnOS performs the in-toto verification steps, running the inspections on the OS,
+// and reports the result of each step and inspection.
+func verifyOnOS(verificationDir string) (*Report, error) {
+	rootLayout, rootLayoutPubKeys, rootLayoutFileName, err := loadVerificationDir(verificationDir)
+	if err != nil {
+		return nil, err
+	}
+
+	report := verifyWithReport(rootLayout, rootLayoutPubKeys, verificationDir, verificationDir, true)
+	report.Layout = filepath.Base(rootLayoutFileName)
+	if err := report.Err(); err != nil {
+		return report, err
+	}
+
+	log.Infof("Verification succeeded for layout %v", rootLayoutFileName)
+	return report, nil
+}
+
+// loadVerificationDir loads and validates the root layout of a verification directory, and its public keys.
+func loadVerificationDir(verificationDir string) (in_toto.Metablock, map[string]in_toto.Key, string, error) {
+	var rootLayout in_toto.Metablock
 	var rootLayoutPubKey in_toto.Key
 	rootLayoutPubKeys := make(map[string]in_toto.Key)
 	filenames, err := getFilesWithSuffix(verificationDir, ".pub")
 	if err != nil {
-		return fmt.Errorf("cannot read root layout pubkeys in %v: %v", verificationDir, err)
+		return rootLayout, nil, "", fmt.Errorf("cannot read root layout pubkeys in %v: %v", verificationDir, err)
 	}
 	for _, filename := range filenames {
 		err = rootLayoutPubKey.LoadKey(filename, "rsassa-pss-sha256", []string{"sha256", "sha512"})
 		if err != nil {
-			return fmt.Errorf("cannot load layout public key %v: %v", filename, err)
+			return rootLayout, nil, "", fmt.Errorf("cannot load layout public key %v: %v", filename, err)
 		}
 		rootLayoutPubKeys[rootLayoutPubKey.KeyID] = rootLayoutPubKey
 	}
 
-	var rootLayout in_toto.Metablock
 	rootLayoutFileName, err := getRootLayoutPath(verificationDir)
 	if err != nil {
-		return err
+		return rootLayout, nil, "", err
 	}
 	if err := rootLayout.Load(rootLayoutFileName); err != nil {
-		return fmt.Errorf("cannot load root layout from %v: %v", rootLayoutFileName, err)
+		return rootLayout, nil, "", fmt.Errorf("cannot load root layout from %v: %v", rootLayoutFileName, err)
 	}
 
 	if err := ValidateLayout(rootLayout.Signed.(in_toto.Layout)); err != nil {
-		return fmt.Errorf("invalid metadata found: %v", err)
+		return rootLayout, nil, "", fmt.Errorf("invalid metadata found: %v", err)
 	}
+	return rootLayout, rootLayoutPubKeys, rootLayoutFileName, nil
+}
 
-	//TODO: get parameter substitutions, if any, from user
-	parameterSubstitutions := make(map[string]string)
-	if _, err := in_toto.InTotoVerifyWithDirectory(rootLayout, rootLayoutPubKeys, verificationDir, verificationDir, "", parameterSubstitutions); err != nil {
-		return fmt.Errorf("failed verification: %v", err)
+// verifySignatures verifies the root layout signatures against the layout keys and threshold,
+// then the link signatures against the functionary keys and thresholds of each step of the layout.
+// Unlike verifyOnOS, no inspection is executed and no artifact rule is checked.
+func verifySignatures(layoutPath, linkDir string, layoutKeys []string, threshold int) error {
+	var keys [][]byte
+	for _, p := range layoutKeys {
+		k, err := ioutil.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("cannot load layout public key %v: %v", p, err)
+		}
+		keys = append(keys, k)
 	}
 
-	log.Infof("Verification succeeded for layout %v", rootLayoutFileName)
+	var rootLayout in_toto.Metablock
+	if err := rootLayout.Load(layoutPath); err != nil {
+		return fmt.Errorf("cannot load root layout from %v: %v", layoutPath, err)
+	}
+	layout, ok := rootLayout.Signed.(in_toto.Layout)
+	if !ok {
+		return fmt.Errorf("%v is not a layout", layoutPath)
+	}
+	if _, err := verifyLayoutThreshold(rootLayout, keys, threshold); err != nil {
+		return fmt.Errorf("invalid root layout signature: %v", err)
+	}
+
+	stepsMetadata, err := in_toto.LoadLinksForLayout(layout, linkDir)
+	if err != nil {
+		return fmt.Errorf("cannot load links: %v", err)
+	}
+	if _, err := in_toto.VerifyLinkSignatureThesholds(layout, stepsMetadata); err != nil {
+		return fmt.Errorf("invalid link signatures: %v", err)
+	}
+
+	log.Infof("Signatures verified for layout %v and links in %v", layoutPath, linkDir)
 	return nil
 }
 
+// verifyLayoutThreshold returns the public keys of the layout owners that signed the root layout,
+// and fails if there are fewer than threshold of them. A threshold of 0 requires all owners to sign.
+func verifyLayoutThreshold(rootLayout in_toto.Metablock, keys [][]byte, threshold int) ([][]byte, error) {
+	owners := make(map[string]bool)
+	var signers [][]byte
+	for _, k := range keys {
+		var key in_toto.Key
+		if err := key.LoadKeyReader(bytes.NewReader(k), DefaultKeyScheme, keyIDHashAlgorithms); err != nil {
+			return nil, fmt.Errorf("cannot load layout public key: %v", err)
+		}
+		if owners[key.KeyID] {
+			continue
+		}
+		owners[key.KeyID] = true
+
+		if err := rootLayout.VerifySignature(key); err != nil {
+			log.Debugf("No valid root layout signature from key %v: %v", key.KeyID, err)
+			continue
+		}
+		signers = append(signers, k)
+	}
+
+	if len(owners) == 0 {
+		return nil, fmt.Errorf("no root layout public key")
+	}
+	if threshold == 0 {
+		threshold = len(owners)
+	}
+	if len(signers) < threshold {
+		return nil, fmt.Errorf("root layout requires %v valid signature(s) from its owners, found %v", threshold, len(signers))
+	}
+	return signers, nil
+}
+
+// decodeLayout decodes a root layout stored in the TUF custom field.
+func decodeLayout(b []byte) (in_toto.Metablock, error) {
+	var mb in_toto.Metablock
+	var raw struct {
+		Signed     json.RawMessage     `json:"signed"`
+		Signatures []in_toto.Signature `json:"signatures"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return mb, fmt.Errorf("cannot decode root layout: %v", err)
+	}
+	var layout in_toto.Layout
+	if err := json.Unmarshal(raw.Signed, &layout); err != nil {
+		return mb, fmt.Errorf("cannot decode root layout: %v", err)
+	}
+	if layout.Type != "layout" {
+		return mb, fmt.Errorf("root layout is not a layout")
+	}
+	mb.Signed = layout
+	mb.Signatures = raw.Signatures
+	return mb, nil
+}
+
 // ValidateLayout is a function used to ensure that a passed item of type Layout
 // matches the necessary format.
 func ValidateLayout(layout in_toto.Layout) error {
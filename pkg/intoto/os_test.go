@@ -1,6 +1,7 @@
 package intoto
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -11,7 +12,7 @@ import (
 var testDir = "../../testdata/intoto"
 
 func TestVerify(t *testing.T) {
-	err := verifyOnOS(testDir)
+	_, err := verifyOnOS(testDir)
 	assert.NoError(t, err)
 
 	// the verification step generates a file called untar.link
@@ -44,3 +45,19 @@ func TestValidateMalformed(t *testing.T) {
 	err = ValidateLayout(*l)
 	assert.Error(t, err)
 }
+
+func TestVerifySignatures(t *testing.T) {
+	layoutPath := filepath.Join(testDir, "root.layout")
+	keyPath := filepath.Join(testDir, "alice.pub")
+
+	err := verifySignatures(layoutPath, testDir, []string{keyPath}, 0)
+	assert.NoError(t, err)
+
+	// without the links, the step thresholds cannot be met.
+	emptyDir, err := ioutil.TempDir("", "links")
+	assert.NoError(t, err)
+	defer os.RemoveAll(emptyDir)
+
+	err = verifySignatures(layoutPath, emptyDir, []string{keyPath}, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,396 @@
+package intoto
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// TextReport is the human readable format of verification reports
+	TextReport = "text"
+	// JSONReport is the JSON format of verification reports
+	JSONReport = "json"
+
+	rulePassed  = "passed"
+	ruleFailed  = "failed"
+	ruleSkipped = "skipped"
+)
+
+// Report is the result of an in-toto verification for each step and inspection of the root layout,
+// so that a failed verification can be traced to the part of the supply chain that broke.
+type Report struct {
+	Layout string `json:"layout"`
+	Passed bool   `json:"passed"`
+	// Error is the first failure of the verification
+	Error       string              `json:"error,omitempty"`
+	Steps       []*StepReport       `json:"steps"`
+	Inspections []*InspectionReport `json:"inspections"`
+	// Container is the result of the verification in container, if any
+	Container *ContainerReport `json:"container,omitempty"`
+}
+
+// StepReport is the verification result of a step of the root layout
+type StepReport struct {
+	Name      string `json:"name"`
+	Threshold int    `json:"threshold"`
+	// Functionaries are the key IDs of the functionaries authorized to perform the step
+	Functionaries []string `json:"functionaries"`
+	// Links are the key IDs of the functionaries whose link was found
+	Links []string `json:"links"`
+	// Signers are the key IDs of the functionaries whose link has a valid signature
+	Signers   []string      `json:"signers"`
+	Sublayout bool          `json:"sublayout,omitempty"`
+	Rules     []*RuleReport `json:"rules"`
+	Passed    bool          `json:"passed"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// InspectionReport is the verification result of an inspection of the root layout
+type InspectionReport struct {
+	Name string   `json:"name"`
+	Run  []string `json:"run"`
+	// ReturnValue is the exit code of the inspection command, if it was run
+	ReturnValue *int          `json:"returnValue,omitempty"`
+	Rules       []*RuleReport `json:"rules"`
+	Passed      bool          `json:"passed"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// RuleReport is the result of an artifact rule of a step or inspection
+type RuleReport struct {
+	// Artifacts is either "materials" or "products"
+	Artifacts string   `json:"artifacts"`
+	Rule      []string `json:"rule"`
+	// Result is "passed", "failed", or "skipped" if the rule was not evaluated
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ContainerReport is the result of the verification in container
+type ContainerReport struct {
+	Image  string `json:"image"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+	// ExitCode is the status code of the verification container, if it exited
+	ExitCode *int64 `json:"exitCode,omitempty"`
+	// Output is the output of the verification container, if it failed
+	Output string `json:"output,omitempty"`
+}
+
+// Err returns the first failure of the verification, if any
+func (r *Report) Err() error {
+	if r.Passed {
+		return nil
+	}
+	return fmt.Errorf("failed verification: %v", r.Error)
+}
+
+// WriteReport writes the report in the text or JSON format
+func (r *Report) WriteReport(w io.Writer, format string) error {
+	switch format {
+	case JSONReport:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case TextReport:
+		return r.writeText(w)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+func (r *Report) writeText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Layout %v: %v\n", r.Layout, result(r.Passed, r.Error))
+	for _, s := range r.Steps {
+		fmt.Fprintf(&b, "Step %v: %v\n", s.Name, result(s.Passed, s.Error))
+		fmt.Fprintf(&b, "  links: %d of %d required with a valid signature (functionaries %v, links found %v, valid %v)\n",
+			len(s.Signers), s.Threshold, shortKeyIDs(s.Functionaries), shortKeyIDs(s.Links), shortKeyIDs(s.Signers))
+		if s.Sublayout {
+			fmt.Fprintf(&b, "  sublayout\n")
+		}
+		writeRules(&b, s.Rules)
+	}
+	for _, i := range r.Inspections {
+		fmt.Fprintf(&b, "Inspection %v: %v\n", i.Name, result(i.Passed, i.Error))
+		if i.ReturnValue != nil {
+			fmt.Fprintf(&b, "  %v returned %d\n", strings.Join(i.Run, " "), *i.ReturnValue)
+		}
+		writeRules(&b, i.Rules)
+	}
+	if r.Container != nil {
+		fmt.Fprintf(&b, "Container %v: %v\n", r.Container.Image, result(r.Container.Passed, r.Container.Error))
+		if r.Container.Output != "" {
+			for _, l := range strings.Split(strings.TrimSuffix(r.Container.Output, "\n"), "\n") {
+				fmt.Fprintf(&b, "  %v\n", l)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeRules(b *strings.Builder, rules []*RuleReport) {
+	for _, rr := range rules {
+		fmt.Fprintf(b, "  %v %v: %v", rr.Artifacts, strings.Join(rr.Rule, " "), rr.Result)
+		if rr.Error != "" {
+			fmt.Fprintf(b, ": %v", rr.Error)
+		}
+		fmt.Fprintln(b)
+	}
+}
+
+func result(passed bool, err string) string {
+	switch {
+	case passed:
+		return "passed"
+	case err != "":
+		return "FAILED: " + err
+	default:
+		return "not verified"
+	}
+}
+
+func shortKeyIDs(keyIDs []string) []string {
+	short := make([]string, 0, len(keyIDs))
+	for _, k := range keyIDs {
+		if len(k) > 8 {
+			k = k[:8]
+		}
+		short = append(short, k)
+	}
+	return short
+}
+
+// fail records the first failure of the verification
+func (r *Report) fail(err error) {
+	if r.Passed || r.Error == "" {
+		r.Error = err.Error()
+	}
+	r.Passed = false
+}
+
+func (s *StepReport) fail(err error) {
+	s.Passed = false
+	s.Error = err.Error()
+}
+
+func (i *InspectionReport) fail(err error) {
+	i.Passed = false
+	i.Error = err.Error()
+}
+
+// verifyWithReport performs the in-toto verification of a root layout against the links in linkDir, running the
+// inspections in runDir, and reports the result of each step and inspection. It calls the checks of the in-toto
+// library in the order of in_toto.InTotoVerifyWithDirectory, once each, and records their outcome as they happen,
+// so the report is the verification itself. Steps are checked one at a time, to attribute a failure to its step.
+// If runInspections is false, the verification stops before the inspections, which are reported as not verified.
+func verifyWithReport(rootLayout in_toto.Metablock, layoutKeys map[string]in_toto.Key, linkDir, runDir string, runInspections bool) *Report {
+	r := &Report{Layout: "root.layout", Passed: true}
+	layout, ok := rootLayout.Signed.(in_toto.Layout)
+	if !ok {
+		r.fail(fmt.Errorf("root layout is not a layout"))
+		return r
+	}
+	for _, step := range layout.Steps {
+		r.Steps = append(r.Steps, &StepReport{
+			Name:          step.Name,
+			Threshold:     step.Threshold,
+			Functionaries: step.PubKeys,
+			Rules:         ruleReports(step.ExpectedMaterials, step.ExpectedProducts),
+		})
+	}
+	for _, inspection := range layout.Inspect {
+		r.Inspections = append(r.Inspections, &InspectionReport{
+			Name:  inspection.Name,
+			Run:   inspection.Run,
+			Rules: ruleReports(inspection.ExpectedMaterials, inspection.ExpectedProducts),
+		})
+	}
+
+	if err := in_toto.VerifyLayoutSignatures(rootLayout, layoutKeys); err != nil {
+		r.fail(fmt.Errorf("invalid root layout signature: %v", err))
+		return r
+	}
+	if err := in_toto.VerifyLayoutExpiration(layout); err != nil {
+		r.fail(err)
+		return r
+	}
+	//TODO: get parameter substitutions, if any, from user
+	layout, err := in_toto.SubstituteParameters(layout, make(map[string]string))
+	if err != nil {
+		r.fail(err)
+		return r
+	}
+
+	stepsMetadata := make(map[string]map[string]in_toto.Metablock)
+	for i, step := range layout.Steps {
+		links, err := verifyStepLinks(layout, step, linkDir, r.Steps[i])
+		if err != nil {
+			r.Steps[i].fail(err)
+			r.fail(fmt.Errorf("step '%v': %v", step.Name, err))
+			continue
+		}
+		stepsMetadata[step.Name] = links
+	}
+	if !r.Passed {
+		return r
+	}
+	in_toto.VerifyStepCommandAlignment(layout, stepsMetadata)
+
+	reduced, err := in_toto.ReduceStepsMetadata(layout, stepsMetadata)
+	if err != nil {
+		r.fail(err)
+		return r
+	}
+	for i, step := range layout.Steps {
+		s := step
+		newItem := func(materials, products [][]string) interface{} {
+			s.ExpectedMaterials, s.ExpectedProducts = materials, products
+			return s
+		}
+		if err := verifyRules(newItem, step.ExpectedMaterials, step.ExpectedProducts, reduced, r.Steps[i].Rules); err != nil {
+			r.Steps[i].fail(err)
+			r.fail(fmt.Errorf("step '%v': %v", step.Name, err))
+			continue
+		}
+		r.Steps[i].Passed = true
+	}
+	if !r.Passed || !runInspections {
+		return r
+	}
+
+	// inspection rules may also refer to the artifacts reported by the step links
+	itemsMetadata := make(map[string]in_toto.Metablock)
+	for k, v := range reduced {
+		itemsMetadata[k] = v
+	}
+	for i, inspection := range layout.Inspect {
+		link, err := runInspection(inspection, runDir, r.Inspections[i])
+		if err != nil {
+			// as in the in-toto library, the inspections after a failed one are not run
+			r.Inspections[i].fail(err)
+			r.fail(fmt.Errorf("inspection '%v': %v", inspection.Name, err))
+			return r
+		}
+		itemsMetadata[inspection.Name] = link
+	}
+	for i, inspection := range layout.Inspect {
+		ins := inspection
+		newItem := func(materials, products [][]string) interface{} {
+			ins.ExpectedMaterials, ins.ExpectedProducts = materials, products
+			return ins
+		}
+		if err := verifyRules(newItem, inspection.ExpectedMaterials, inspection.ExpectedProducts, itemsMetadata, r.Inspections[i].Rules); err != nil {
+			r.Inspections[i].fail(err)
+			r.fail(fmt.Errorf("inspection '%v': %v", inspection.Name, err))
+			continue
+		}
+		r.Inspections[i].Passed = true
+	}
+	return r
+}
+
+// verifyStepLinks loads the links of a step and checks their signatures against its threshold, then resolves
+// its sublayouts to their summary link, with the in-toto library on a layout restricted to the step.
+// The links found and those with a valid signature are recorded whether or not the threshold is met.
+func verifyStepLinks(layout in_toto.Layout, step in_toto.Step, linkDir string, sr *StepReport) (map[string]in_toto.Metablock, error) {
+	stepLayout := layout
+	stepLayout.Steps = []in_toto.Step{step}
+
+	// without a threshold, loading the links and checking their signatures cannot fail
+	unbounded := step
+	unbounded.Threshold = 0
+	unboundedLayout := layout
+	unboundedLayout.Steps = []in_toto.Step{unbounded}
+	loaded, _ := in_toto.LoadLinksForLayout(unboundedLayout, linkDir)
+	verified, _ := in_toto.VerifyLinkSignatureThesholds(unboundedLayout, loaded)
+	for keyID := range loaded[step.Name] {
+		sr.Links = append(sr.Links, keyID)
+	}
+	for keyID, link := range verified[step.Name] {
+		sr.Signers = append(sr.Signers, keyID)
+		if _, ok := link.Signed.(in_toto.Layout); ok {
+			sr.Sublayout = true
+		}
+	}
+	sort.Strings(sr.Links)
+	sort.Strings(sr.Signers)
+
+	if _, err := in_toto.LoadLinksForLayout(stepLayout, linkDir); err != nil {
+		return nil, err
+	}
+	verified, err := in_toto.VerifyLinkSignatureThesholds(stepLayout, loaded)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := in_toto.VerifySublayouts(stepLayout, verified, linkDir)
+	if err != nil {
+		return nil, fmt.Errorf("sublayout verification failed: %v", err)
+	}
+	return resolved[step.Name], nil
+}
+
+// runInspection runs the command of an inspection in runDir, and returns its unsigned link. It does what
+// in_toto.RunInspections does for each inspection, which does not return the link of a failed inspection,
+// so that the return value of the command is reported in any case.
+func runInspection(inspection in_toto.Inspection, runDir string, ir *InspectionReport) (in_toto.Metablock, error) {
+	paths := []string{runDir}
+	link, err := in_toto.InTotoRun(inspection.Name, runDir, paths, paths, inspection.Run, in_toto.Key{}, artifactHashAlgorithms, nil)
+	if err != nil {
+		return link, fmt.Errorf("cannot run inspection: %v", err)
+	}
+	if rv, ok := link.Signed.(in_toto.Link).ByProducts["return-value"].(float64); ok {
+		v := int(rv)
+		ir.ReturnValue = &v
+	}
+	if ir.ReturnValue == nil || *ir.ReturnValue != 0 {
+		return link, fmt.Errorf("inspection command '%v' returned a non-zero value", strings.Join(inspection.Run, " "))
+	}
+
+	// as in the in-toto library, the inspection link is written to the working directory
+	if err := link.Dump(fmt.Sprintf(in_toto.LinkNameFormatShort, inspection.Name)); err != nil {
+		log.Warnf("cannot write the link of inspection %v: %v", inspection.Name, err)
+	}
+	return link, nil
+}
+
+func ruleReports(materials, products [][]string) []*RuleReport {
+	var rules []*RuleReport
+	for _, r := range materials {
+		rules = append(rules, &RuleReport{Artifacts: "materials", Rule: r, Result: ruleSkipped})
+	}
+	for _, r := range products {
+		rules = append(rules, &RuleReport{Artifacts: "products", Rule: r, Result: ruleSkipped})
+	}
+	return rules
+}
+
+// verifyRules checks the artifact rules of a step or inspection one at a time, to report the rule that fails.
+// Since a rule only applies to the artifacts left by the rules before it, VerifyArtifacts is run on growing
+// prefixes of the material rules, then of the product rules. newItem returns the step or inspection with
+// the given rules.
+func verifyRules(newItem func(materials, products [][]string) interface{}, materials, products [][]string,
+	itemsMetadata map[string]in_toto.Metablock, rules []*RuleReport) error {
+	for i, rr := range rules {
+		m, p := materials, products[:0]
+		if i < len(materials) {
+			m = materials[:i+1]
+		} else {
+			p = products[:i-len(materials)+1]
+		}
+		if err := in_toto.VerifyArtifacts([]interface{}{newItem(m, p)}, itemsMetadata); err != nil {
+			rr.Result = ruleFailed
+			rr.Error = err.Error()
+			return fmt.Errorf("%v rule %v failed: %v", rr.Artifacts, strings.Join(rr.Rule, " "), err)
+		}
+		rr.Result = rulePassed
+	}
+	return nil
+}
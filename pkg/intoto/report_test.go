@@ -0,0 +1,208 @@
+package intoto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cnabio/signy/pkg/docker"
+)
+
+const testReportLayoutSpec = `
+keys:
+  bob: %[1]v/functionary/functionary.pub
+steps:
+- name: write
+  functionaries: [bob]
+  expected_products:
+  - ALLOW %[1]v/foo.py
+  - DISALLOW %[1]v/*
+inspections:
+- name: succeed
+  run: ["true"]
+- name: fail
+  run: ["false"]
+`
+
+func TestVerifyWithReport(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "in-toto-report")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+	// the links of the inspections are written to the working directory.
+	wd, err := os.Getwd()
+	is.NoError(err)
+	is.NoError(os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	functionaryDir := filepath.Join(dir, "functionary")
+	is.NoError(os.Mkdir(functionaryDir, 0755))
+	functionary := generateTestKey(t, functionaryDir)
+	is.NoError(ioutil.WriteFile(filepath.Join(functionaryDir, "functionary.pub"), []byte(functionary.KeyVal.Public), 0644))
+	ownerDir := filepath.Join(dir, "owner")
+	is.NoError(os.Mkdir(ownerDir, 0755))
+	owner := generateTestKey(t, ownerDir)
+
+	specPath := filepath.Join(dir, "layout.yaml")
+	is.NoError(ioutil.WriteFile(specPath, []byte(fmt.Sprintf(testReportLayoutSpec, dir)), 0644))
+	layoutPath := filepath.Join(dir, "root.layout")
+	is.NoError(CreateLayout(specPath, owner, layoutPath))
+	var rootLayout in_toto.Metablock
+	is.NoError(rootLayout.Load(layoutPath))
+	layoutKeys := map[string]in_toto.Key{owner.KeyID: owner}
+
+	foo, bar := filepath.Join(dir, "foo.py"), filepath.Join(dir, "bar.py")
+	is.NoError(ioutil.WriteFile(foo, []byte("print('foo')"), 0644))
+	is.NoError(ioutil.WriteFile(bar, []byte("print('bar')"), 0644))
+	_, err = RunStep("write", functionary, nil, []string{foo, bar}, nil, []string{"true"}, dir)
+	is.NoError(err)
+
+	// the unexpected product is reported against the rule that disallows it.
+	report := verifyWithReport(rootLayout, layoutKeys, dir, dir, true)
+	is.False(report.Passed)
+	is.Contains(report.Error, "step 'write'")
+	step := report.Steps[0]
+	is.Equal([]string{functionary.KeyID}, step.Signers)
+	is.Equal(rulePassed, step.Rules[0].Result)
+	is.Equal(ruleFailed, step.Rules[1].Result)
+	is.Nil(report.Inspections[0].ReturnValue)
+
+	is.NoError(os.Remove(bar))
+	_, err = RunStep("write", functionary, nil, []string{foo}, nil, []string{"true"}, dir)
+	is.NoError(err)
+
+	report = verifyWithReport(rootLayout, layoutKeys, dir, dir, true)
+	is.False(report.Passed)
+	is.Contains(report.Error, "inspection 'fail'")
+	is.True(report.Steps[0].Passed)
+	// the rules of the inspections are not verified once an inspection fails.
+	is.Equal(0, *report.Inspections[0].ReturnValue)
+	is.Empty(report.Inspections[0].Error)
+	is.False(report.Inspections[1].Passed)
+	is.Equal(1, *report.Inspections[1].ReturnValue)
+
+	var b bytes.Buffer
+	is.NoError(report.WriteReport(&b, JSONReport))
+	decoded := &Report{}
+	is.NoError(json.Unmarshal(b.Bytes(), decoded))
+	is.Equal(report, decoded)
+	b.Reset()
+	is.NoError(report.WriteReport(&b, TextReport))
+	is.Contains(b.String(), "Inspection fail: FAILED")
+
+	// without inspections, the steps are verified on their own.
+	report = verifyWithReport(rootLayout, layoutKeys, dir, dir, false)
+	is.True(report.Passed)
+	is.Nil(report.Inspections[1].ReturnValue)
+
+	// links must be signed by an authorized functionary.
+	report = verifyWithReport(rootLayout, layoutKeys, ownerDir, dir, false)
+	is.False(report.Passed)
+	is.Empty(report.Steps[0].Links)
+	is.Equal(ruleSkipped, report.Steps[0].Rules[0].Result)
+}
+
+const testInspectionRuleLayoutSpec = `
+keys:
+  bob: %[1]v/functionary/functionary.pub
+steps:
+- name: write
+  functionaries: [bob]
+  expected_products:
+  - MATCH * WITH PRODUCTS FROM check
+  - DISALLOW *
+inspections:
+- name: check
+  run: ["true"]
+`
+
+func TestVerifyWithReportInspectionRule(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "in-toto-report")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+	// the links of the inspections are written to the working directory.
+	wd, err := os.Getwd()
+	is.NoError(err)
+	is.NoError(os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	functionaryDir := filepath.Join(dir, "functionary")
+	is.NoError(os.Mkdir(functionaryDir, 0755))
+	functionary := generateTestKey(t, functionaryDir)
+	is.NoError(ioutil.WriteFile(filepath.Join(functionaryDir, "functionary.pub"), []byte(functionary.KeyVal.Public), 0644))
+	ownerDir := filepath.Join(dir, "owner")
+	is.NoError(os.Mkdir(ownerDir, 0755))
+	owner := generateTestKey(t, ownerDir)
+
+	specPath := filepath.Join(ownerDir, "layout.yaml")
+	is.NoError(ioutil.WriteFile(specPath, []byte(fmt.Sprintf(testInspectionRuleLayoutSpec, dir)), 0644))
+	layoutPath := filepath.Join(ownerDir, "root.layout")
+	is.NoError(CreateLayout(specPath, owner, layoutPath))
+	var rootLayout in_toto.Metablock
+	is.NoError(rootLayout.Load(layoutPath))
+	layoutKeys := map[string]in_toto.Key{owner.KeyID: owner}
+
+	runDir := filepath.Join(dir, "run")
+	is.NoError(os.Mkdir(runDir, 0755))
+	is.NoError(ioutil.WriteFile(filepath.Join(runDir, "foo.py"), []byte("print('foo')"), 0644))
+	is.NoError(os.Chdir(runDir))
+	_, err = RunStep("write", functionary, nil, []string{"foo.py"}, nil, []string{"true"}, dir)
+	is.NoError(err)
+	is.NoError(os.Chdir(dir))
+
+	// the report agrees with the in-toto library, which verifies the rules of the steps against the links of
+	// the steps only, so the product of the step is not matched by the inspection, and is disallowed.
+	_, libErr := in_toto.InTotoVerifyWithDirectory(rootLayout, layoutKeys, dir, runDir, "", make(map[string]string))
+	is.Error(libErr)
+	report := verifyWithReport(rootLayout, layoutKeys, dir, runDir, true)
+	is.False(report.Passed)
+	is.Contains(report.Error, "step 'write'")
+	is.Equal(rulePassed, report.Steps[0].Rules[0].Result)
+	is.Equal(ruleFailed, report.Steps[0].Rules[1].Result)
+	is.Nil(report.Inspections[0].ReturnValue)
+
+	report = verifyWithReport(rootLayout, layoutKeys, dir, runDir, false)
+	is.False(report.Passed)
+	is.False(report.Steps[0].Passed)
+}
+
+func TestVerifyInContainerReport(t *testing.T) {
+	is := assert.New(t)
+
+	rt := docker.NewFakeRuntime(func(spec docker.ContainerSpec, files map[string][]byte) (int64, string) {
+		if _, ok := files["root.layout"]; !ok {
+			return 1, "root.layout not found\n"
+		}
+		return 0, ""
+	})
+	opts := ContainerOptions{Image: "verifier:test", Runtime: rt, Sandbox: docker.DefaultSandbox()}
+
+	report, err := verifyInContainer(&Report{Passed: true}, testDir, opts)
+	is.NoError(err)
+	is.True(report.Container.Passed)
+	is.Equal(int64(0), *report.Container.ExitCode)
+	is.Equal("verifier:test", report.Container.Image)
+
+	emptyDir, err := ioutil.TempDir("", "in-toto")
+	is.NoError(err)
+	defer os.RemoveAll(emptyDir)
+	report, err = verifyInContainer(&Report{Passed: true}, emptyDir, opts)
+	is.Error(err)
+	is.False(report.Passed)
+	is.False(report.Container.Passed)
+	is.Equal(int64(1), *report.Container.ExitCode)
+	is.Equal("root.layout not found\n", report.Container.Output)
+	for _, c := range rt.Containers {
+		is.True(c.Removed)
+	}
+}
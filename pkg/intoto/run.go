@@ -0,0 +1,131 @@
+package intoto
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultKeyScheme is the signature scheme of the in-toto keys used by signy
+	DefaultKeyScheme = "rsassa-pss-sha256"
+
+	// unfinishedLinkNameFormat is the name of the link written by RecordStart, as in-toto-record names it
+	unfinishedLinkNameFormat = ".%s.%.8s.link-unfinished"
+)
+
+var (
+	// keyIDHashAlgorithms must match the ones used to compute the key IDs in the layout
+	keyIDHashAlgorithms = []string{"sha256", "sha512"}
+	// artifactHashAlgorithms are the algorithms used to record materials and products
+	artifactHashAlgorithms = []string{"sha256"}
+)
+
+// LoadKey loads an in-toto key from a PEM file.
+func LoadKey(path, scheme string) (in_toto.Key, error) {
+	var key in_toto.Key
+	if err := key.LoadKey(path, scheme, keyIDHashAlgorithms); err != nil {
+		return key, fmt.Errorf("cannot load key %v: %v", path, err)
+	}
+	return key, nil
+}
+
+// RunStep runs the command of a supply chain step, records its materials and products,
+// and writes the signed link into linkDir. It returns the path of the link.
+// The link is written even if the command fails, in which case an error is also returned.
+func RunStep(name string, key in_toto.Key, materials, products, exclude, cmdArgs []string, linkDir string) (string, error) {
+	if len(cmdArgs) == 0 {
+		return "", fmt.Errorf("no command to run for step %v", name)
+	}
+
+	log.Infof("Running step %v: %v", name, cmdArgs)
+	linkMb, err := in_toto.InTotoRun(name, "", materials, products, cmdArgs, key, artifactHashAlgorithms, exclude)
+	if err != nil {
+		return "", fmt.Errorf("cannot run step %v: %v", name, err)
+	}
+
+	linkPath := filepath.Join(linkDir, fmt.Sprintf(in_toto.LinkNameFormat, name, key.KeyID))
+	if err := linkMb.Dump(linkPath); err != nil {
+		return "", fmt.Errorf("cannot write link %v: %v", linkPath, err)
+	}
+	log.Infof("Wrote link %v", linkPath)
+
+	if rv := linkMb.Signed.(in_toto.Link).ByProducts["return-value"]; rv != float64(0) {
+		return linkPath, fmt.Errorf("command of step %v returned a non-zero value: %v", name, rv)
+	}
+	return linkPath, nil
+}
+
+// RecordStart records the materials of a supply chain step whose commands are run
+// outside of signy, and writes a signed, unfinished link into linkDir.
+func RecordStart(name string, key in_toto.Key, materials, exclude []string, linkDir string) (string, error) {
+	m, err := in_toto.RecordArtifacts(materials, artifactHashAlgorithms, exclude)
+	if err != nil {
+		return "", fmt.Errorf("cannot record materials: %v", err)
+	}
+
+	linkMb := in_toto.Metablock{
+		Signed: in_toto.Link{
+			Type:        "link",
+			Name:        name,
+			Materials:   m,
+			Products:    map[string]interface{}{},
+			ByProducts:  map[string]interface{}{},
+			Command:     []string{},
+			Environment: map[string]interface{}{},
+		},
+		Signatures: []in_toto.Signature{},
+	}
+	if err := linkMb.Sign(key); err != nil {
+		return "", fmt.Errorf("cannot sign link: %v", err)
+	}
+
+	p := filepath.Join(linkDir, fmt.Sprintf(unfinishedLinkNameFormat, name, key.KeyID))
+	if err := linkMb.Dump(p); err != nil {
+		return "", fmt.Errorf("cannot write unfinished link %v: %v", p, err)
+	}
+	log.Infof("Recorded materials of step %v in %v", name, p)
+	return p, nil
+}
+
+// RecordStop loads the unfinished link written by RecordStart, verifies its signature,
+// records the products of the step and writes the signed link into linkDir.
+func RecordStop(name string, key in_toto.Key, products, exclude []string, linkDir string) (string, error) {
+	unfinished := filepath.Join(linkDir, fmt.Sprintf(unfinishedLinkNameFormat, name, key.KeyID))
+
+	var linkMb in_toto.Metablock
+	if err := linkMb.Load(unfinished); err != nil {
+		return "", fmt.Errorf("cannot load unfinished link %v, was the recording started?: %v", unfinished, err)
+	}
+	if err := linkMb.VerifySignature(key); err != nil {
+		return "", fmt.Errorf("invalid signature for unfinished link %v: %v", unfinished, err)
+	}
+	link, ok := linkMb.Signed.(in_toto.Link)
+	if !ok || link.Name != name {
+		return "", fmt.Errorf("unfinished link %v is not a link for step %v", unfinished, name)
+	}
+
+	p, err := in_toto.RecordArtifacts(products, artifactHashAlgorithms, exclude)
+	if err != nil {
+		return "", fmt.Errorf("cannot record products: %v", err)
+	}
+	link.Products = p
+	linkMb.Signed = link
+	linkMb.Signatures = []in_toto.Signature{}
+	if err := linkMb.Sign(key); err != nil {
+		return "", fmt.Errorf("cannot sign link: %v", err)
+	}
+
+	linkPath := filepath.Join(linkDir, fmt.Sprintf(in_toto.LinkNameFormat, name, key.KeyID))
+	if err := linkMb.Dump(linkPath); err != nil {
+		return "", fmt.Errorf("cannot write link %v: %v", linkPath, err)
+	}
+	if err := os.Remove(unfinished); err != nil {
+		return "", err
+	}
+	log.Infof("Wrote link %v", linkPath)
+	return linkPath, nil
+}
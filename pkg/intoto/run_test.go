@@ -0,0 +1,86 @@
+package intoto
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/stretchr/testify/assert"
+)
+
+// generateTestKey writes a new RSA private key in dir, and returns it loaded as an in-toto key.
+func generateTestKey(t *testing.T, dir string) in_toto.Key {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	p := filepath.Join(dir, "functionary.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	assert.NoError(t, ioutil.WriteFile(p, b, 0600))
+
+	key, err := LoadKey(p, DefaultKeyScheme)
+	assert.NoError(t, err)
+	return key
+}
+
+func TestRunStep(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "in-toto-run")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+	key := generateTestKey(t, dir)
+
+	material := filepath.Join(dir, "foo.py")
+	is.NoError(ioutil.WriteFile(material, []byte("print('foo')"), 0644))
+
+	linkPath, err := RunStep("write-code", key, []string{material}, []string{material}, nil, []string{"true"}, dir)
+	is.NoError(err)
+
+	var mb in_toto.Metablock
+	is.NoError(mb.Load(linkPath))
+	is.NoError(mb.VerifySignature(key))
+	is.Contains(mb.Signed.(in_toto.Link).Products, material)
+
+	_, err = RunStep("write-code", key, nil, nil, nil, []string{"false"}, dir)
+	is.Error(err)
+}
+
+func TestRecord(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "in-toto-record")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+	key := generateTestKey(t, dir)
+
+	material := filepath.Join(dir, "foo.py")
+	is.NoError(ioutil.WriteFile(material, []byte("print('foo')"), 0644))
+	_, err = RecordStart("package", key, []string{material}, nil, dir)
+	is.NoError(err)
+
+	product := filepath.Join(dir, "foo.tar.gz")
+	is.NoError(ioutil.WriteFile(product, []byte("foo"), 0644))
+	linkPath, err := RecordStop("package", key, []string{product}, nil, dir)
+	is.NoError(err)
+
+	var mb in_toto.Metablock
+	is.NoError(mb.Load(linkPath))
+	is.NoError(mb.VerifySignature(key))
+	link := mb.Signed.(in_toto.Link)
+	is.Contains(link.Materials, material)
+	is.Contains(link.Products, product)
+
+	// the unfinished link is removed, and the recorded link is collected for TUF.
+	links, err := readLinks(dir, filepath.Join(dir, "root.layout"))
+	is.NoError(err)
+	is.Len(links, 1)
+
+	_, err = RecordStop("package", key, []string{product}, nil, dir)
+	is.Error(err)
+}
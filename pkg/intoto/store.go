@@ -0,0 +1,258 @@
+package intoto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cnabio/signy/pkg/canonicaljson"
+	"github.com/cnabio/signy/pkg/oci"
+)
+
+const (
+	// ConfigMediaType is the config media type of in-toto metadata pushed as an OCI artifact
+	ConfigMediaType = "application/vnd.cnab.signy.in-toto.config.v1+json"
+	// MetadataMediaType is the layer media type of in-toto metadata pushed as an OCI artifact
+	MetadataMediaType = "application/vnd.cnab.signy.in-toto.metadata.v1+json"
+
+	// OCIStore is the store location that pushes in-toto metadata next to the signed artifact
+	OCIStore = "oci"
+
+	ociScheme  = "oci://"
+	fileScheme = "file://"
+
+	maxMetadataSize = 64 << 20
+)
+
+// ExternalMetadata points to in-toto metadata stored out of band, and is stored in the
+// TUF custom field in place of the metadata itself.
+type ExternalMetadata struct {
+	Digest   string `json:"digest"`
+	Size     int64  `json:"size"`
+	Location string `json:"location"`
+}
+
+// Store is a content addressed store for in-toto metadata kept out of the TUF custom field.
+type Store interface {
+	// Put stores the content addressed by its digest, and returns its location.
+	Put(ctx context.Context, dgst digest.Digest, content []byte) (string, error)
+}
+
+// NewStore returns the store for a location:
+// "oci" pushes the metadata as an OCI artifact in the repository of ref,
+// "oci://<repository>" pushes it in another repository,
+// "http://" and "https://" URLs upload it to a remote blob store,
+// any other location is a local directory. The location of metadata stored in a local directory is recorded
+// relative to the directory, and is read from the directory passed to WithLocalStore when verifying.
+func NewStore(location, ref string) (Store, error) {
+	switch {
+	case location == OCIStore:
+		n, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return nil, err
+		}
+		return &ociStore{repository: n.Name()}, nil
+	case strings.HasPrefix(location, ociScheme):
+		n, err := reference.ParseNormalizedNamed(strings.TrimPrefix(location, ociScheme))
+		if err != nil {
+			return nil, err
+		}
+		return &ociStore{repository: n.Name()}, nil
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return &httpStore{url: strings.TrimSuffix(location, "/")}, nil
+	default:
+		dir, err := filepath.Abs(strings.TrimPrefix(location, fileScheme))
+		if err != nil {
+			return nil, err
+		}
+		return &localStore{dir: dir}, nil
+	}
+}
+
+// StoreExternal puts the canonical in-toto metadata in a store, and returns the
+// TUF custom field that points to it.
+func StoreExternal(ctx context.Context, s Store, custom canonicaljson.RawMessage) (canonicaljson.RawMessage, error) {
+	dgst := digest.FromBytes(custom)
+	location, err := s.Put(ctx, dgst, custom)
+	if err != nil {
+		return nil, fmt.Errorf("cannot store in-toto metadata: %v", err)
+	}
+	log.Infof("Stored in-toto metadata %v at %v", dgst, location)
+
+	return canonicaljson.Marshal(&Metadata{
+		External: &ExternalMetadata{
+			Digest:   dgst.String(),
+			Size:     int64(len(custom)),
+			Location: location,
+		},
+	})
+}
+
+// loadMetadata decodes the in-toto metadata from a TUF custom field,
+// fetching it if it is stored out of band.
+func loadMetadata(ctx context.Context, custom []byte) (*Metadata, error) {
+	m := &Metadata{}
+	if err := json.Unmarshal(custom, m); err != nil {
+		return nil, err
+	}
+	if m.External == nil {
+		return m, nil
+	}
+
+	e := m.External
+	b, err := fetchExternal(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	m = &Metadata{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("cannot decode in-toto metadata from %v: %v", e.Location, err)
+	}
+	if m.External != nil {
+		return nil, fmt.Errorf("in-toto metadata stored out of band cannot point to another location")
+	}
+	return m, nil
+}
+
+// fetchExternal gets the in-toto metadata stored out of band and verifies it
+// against the digest and size stored in TUF.
+func fetchExternal(ctx context.Context, e *ExternalMetadata) ([]byte, error) {
+	dgst, err := digest.Parse(e.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid in-toto metadata digest: %v", err)
+	}
+	if e.Size > maxMetadataSize {
+		return nil, fmt.Errorf("in-toto metadata is too large: %v bytes", e.Size)
+	}
+
+	log.Infof("Fetching in-toto metadata %v from %v", dgst, e.Location)
+	var b []byte
+	switch {
+	case strings.HasPrefix(e.Location, ociScheme):
+		b, err = oci.FetchArtifact(ctx, strings.TrimPrefix(e.Location, ociScheme))
+	case strings.HasPrefix(e.Location, "http://"), strings.HasPrefix(e.Location, "https://"):
+		b, err = httpGet(ctx, e.Location)
+	case strings.HasPrefix(e.Location, "file:"):
+		b, err = readLocal(ctx, e.Location)
+	default:
+		err = fmt.Errorf("unsupported location")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch in-toto metadata from %v: %v", e.Location, err)
+	}
+
+	if int64(len(b)) != e.Size || digest.FromBytes(b) != dgst {
+		return nil, fmt.Errorf("in-toto metadata from %v does not match the trusted digest %v", e.Location, dgst)
+	}
+	return b, nil
+}
+
+type localStoreKey struct{}
+
+// WithLocalStore returns a context in which in-toto metadata stored in a local directory is read from dir.
+func WithLocalStore(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, localStoreKey{}, dir)
+}
+
+// readLocal reads in-toto metadata from the local directory of the context. Its location is
+// a file URL relative to the directory, such as file:sha256/<hex>.
+func readLocal(ctx context.Context, location string) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	rel := u.Opaque
+	if rel == "" || path.IsAbs(rel) || path.Clean(rel) != rel || strings.HasPrefix(rel, "../") {
+		return nil, fmt.Errorf("location must be relative to the local directory of the store")
+	}
+	dir, _ := ctx.Value(localStoreKey{}).(string)
+	if dir == "" {
+		return nil, fmt.Errorf("metadata is stored in a local directory, which must be passed to the verification")
+	}
+	return ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+}
+
+// localStore writes the metadata to a local directory, and records its location relative to the directory,
+// so that the signed metadata does not disclose or depend on the path of the directory on the signing host.
+type localStore struct {
+	dir string
+}
+
+func (s *localStore) Put(ctx context.Context, dgst digest.Digest, content []byte) (string, error) {
+	rel := path.Join(dgst.Algorithm().String(), dgst.Hex())
+	p := filepath.Join(s.dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(p, content, 0644); err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Opaque: rel}).String(), nil
+}
+
+type httpStore struct {
+	url string
+}
+
+func (s *httpStore) Put(ctx context.Context, dgst digest.Digest, content []byte) (string, error) {
+	location := fmt.Sprintf("%s/%s/%s", s.url, dgst.Algorithm(), dgst.Hex())
+	req, err := http.NewRequest(http.MethodPut, location, bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", MetadataMediaType)
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("unexpected status uploading to %v: %v", location, resp.Status)
+	}
+	return location, nil
+}
+
+func httpGet(ctx context.Context, location string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %v", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxMetadataSize+1))
+}
+
+type ociStore struct {
+	repository string
+}
+
+// Put pushes the metadata as an OCI artifact, tagged after its digest so that
+// registries do not garbage collect it.
+func (s *ociStore) Put(ctx context.Context, dgst digest.Digest, content []byte) (string, error) {
+	ref := fmt.Sprintf("%s:%s-%s.intoto", s.repository, dgst.Algorithm(), dgst.Hex())
+	desc, err := oci.PushArtifact(ctx, ref, ConfigMediaType, MetadataMediaType, content)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s@%s", ociScheme, s.repository, desc.Digest), nil
+}
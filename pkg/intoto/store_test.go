@@ -0,0 +1,67 @@
+package intoto
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreExternal(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "in-toto-store")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	custom, err := GetMetadataRawMessage(filepath.Join(testDir, "root.layout"), testDir, []string{filepath.Join(testDir, "alice.pub")}, 0)
+	is.NoError(err)
+
+	store, err := NewStore(dir, "localhost:5000/thin-intoto:v1")
+	is.NoError(err)
+	external, err := StoreExternal(context.Background(), store, custom)
+	is.NoError(err)
+	is.True(len(external) < len(custom))
+
+	// the location is relative to the directory of the store, which the verifier passes.
+	ext, err := loadExternal(external)
+	is.NoError(err)
+	is.Equal("file:"+path.Join("sha256", digest.FromBytes(custom).Hex()), ext.Location)
+	is.NotContains(string(external), dir)
+	_, err = loadMetadata(context.Background(), external)
+	is.Error(err)
+
+	ctx := WithLocalStore(context.Background(), dir)
+	m, err := loadMetadata(ctx, external)
+	is.NoError(err)
+	is.Nil(m.External)
+	is.Contains(m.Links, "clone.776a00e2.link")
+
+	// tampering with the stored metadata must be detected.
+	p := filepath.Join(dir, "sha256", digest.FromBytes(custom).Hex())
+	is.NoError(ioutil.WriteFile(p, []byte(`{"layout":""}`), 0644))
+	_, err = loadMetadata(ctx, external)
+	is.Error(err)
+}
+
+func TestReadLocalOutsideStore(t *testing.T) {
+	ctx := WithLocalStore(context.Background(), testDir)
+	for _, location := range []string{"file:///etc/passwd", "file:../intoto/root.layout", "file:sha256/../../root.layout"} {
+		_, err := readLocal(ctx, location)
+		assert.Error(t, err, location)
+	}
+}
+
+func loadExternal(custom []byte) (*ExternalMetadata, error) {
+	m := &Metadata{}
+	if err := json.Unmarshal(custom, m); err != nil {
+		return nil, err
+	}
+	return m.External, nil
+}
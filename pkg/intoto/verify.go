@@ -1,12 +1,17 @@
 package intoto
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/in-toto/in-toto-golang/in_toto"
 	log "github.com/sirupsen/logrus"
 	"github.com/theupdateframework/notary/client"
 
@@ -18,10 +23,12 @@ const (
 	ReadOnlyMask   = 0400
 )
 
-func VerifyOnOS(target *client.TargetWithRole, bundle []byte) error {
-	verificationDir, err := getVerificationDir(target, bundle)
+// VerifyOnOS performs the in-toto verification of a target on the OS, and reports the result
+// of each step and inspection of the root layout.
+func VerifyOnOS(ctx context.Context, target *client.TargetWithRole, bundle []byte, expiryWarning time.Duration) (*Report, error) {
+	verificationDir, err := getVerificationDir(ctx, target, bundle, expiryWarning)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		os.RemoveAll(verificationDir)
@@ -30,24 +37,119 @@ func VerifyOnOS(target *client.TargetWithRole, bundle []byte) error {
 	return verifyOnOS(verificationDir)
 }
 
-func VerifyInContainer(target *client.TargetWithRole, bundle []byte, verificationImage string, logLevel string) error {
-	verificationDir, err := getVerificationDir(target, bundle)
+// ContainerOptions configures the in-toto verification in container
+type ContainerOptions struct {
+	// Image is the verification image
+	Image string
+	// Runtime runs the verification container
+	Runtime docker.Runtime
+	// Sandbox restricts the verification container
+	Sandbox docker.Sandbox
+}
+
+// VerifyInContainer performs the in-toto verification of a target in a container.
+// The signatures and artifact rules of the steps are first checked on the host with the in-toto library, for the report,
+// and the inspections are only run in the container, within the restrictions of the sandbox.
+func VerifyInContainer(ctx context.Context, target *client.TargetWithRole, bundle []byte, expiryWarning time.Duration, opts ContainerOptions) (*Report, error) {
+	verificationDir, err := getVerificationDir(ctx, target, bundle, expiryWarning)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		os.RemoveAll(verificationDir)
 		os.Remove(verificationDir)
 	}()
-	return docker.Run(verificationImage, verificationDir, logLevel)
+
+	rootLayout, rootLayoutPubKeys, rootLayoutFileName, err := loadVerificationDir(verificationDir)
+	if err != nil {
+		return nil, err
+	}
+	report := verifyWithReport(rootLayout, rootLayoutPubKeys, verificationDir, verificationDir, false)
+	report.Layout = filepath.Base(rootLayoutFileName)
+	if err := report.Err(); err != nil {
+		return report, err
+	}
+
+	return verifyInContainer(report, verificationDir, opts)
 }
 
-func getVerificationDir(target *client.TargetWithRole, bundle []byte) (string, error) {
+func verifyInContainer(report *Report, verificationDir string, opts ContainerOptions) (*Report, error) {
+	report.Container = &ContainerReport{Image: opts.Image, Passed: true}
+	err := docker.Run(opts.Runtime, opts.Image, verificationDir, opts.Sandbox)
+	var exitErr *docker.ExitError
+	if errors.As(err, &exitErr) {
+		report.Container.ExitCode = &exitErr.StatusCode
+		report.Container.Output = exitErr.Output
+	} else if err == nil {
+		var success int64
+		report.Container.ExitCode = &success
+	}
+	if err != nil {
+		report.Container.Passed = false
+		report.Container.Error = err.Error()
+		report.fail(fmt.Errorf("verification in container failed: %v", err))
+	}
+	return report, report.Err()
+}
+
+// VerifyBeforeSign checks the in-toto metadata that is about to be published to TUF:
+// the signatures of the layout against the layout keys and threshold, and the signatures of the links
+// against the keys and thresholds of the layout steps.
+// If artifact is not nil, the full final product verification is also performed on the OS
+// against the artifact being signed.
+func VerifyBeforeSign(layout, linkDir string, layoutKeys []string, threshold int, artifact []byte) error {
+	log.Infof("Verifying in-toto metadata before signing")
+	if err := verifySignatures(layout, linkDir, layoutKeys, threshold); err != nil {
+		return err
+	}
+	if artifact == nil {
+		return nil
+	}
+
+	custom, err := GetMetadataRawMessage(layout, linkDir, layoutKeys, threshold)
+	if err != nil {
+		return fmt.Errorf("cannot get metadata message: %v", err)
+	}
 	m := &Metadata{}
-	err := json.Unmarshal(*target.Custom, m)
+	if err := json.Unmarshal(custom, m); err != nil {
+		return err
+	}
+
+	verificationDir, err := writeVerificationDir(m, artifact, 0)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(verificationDir)
+	_, err = verifyOnOS(verificationDir)
+	return err
+}
+
+func getVerificationDir(ctx context.Context, target *client.TargetWithRole, bundle []byte, expiryWarning time.Duration) (string, error) {
+	m, err := loadMetadata(ctx, *target.Custom)
 	if err != nil {
 		return "", err
 	}
+	return writeVerificationDir(m, bundle, expiryWarning)
+}
+
+func writeVerificationDir(m *Metadata, bundle []byte, expiryWarning time.Duration) (string, error) {
+	rootLayout, err := decodeLayout(m.Layout)
+	if err != nil {
+		return "", err
+	}
+	// Only the keys of the layout owners that signed the root layout are written, since both
+	// the verification on the OS and in container require a signature from every layout key.
+	signers, err := verifyLayoutThreshold(rootLayout, m.layoutKeys(), m.Threshold)
+	if err != nil {
+		return "", fmt.Errorf("invalid root layout signature: %v", err)
+	}
+	trusted := *m
+	trusted.Key = signers[0]
+	trusted.Keys = signers
+	m = &trusted
+	if err := checkLayoutFreshness(rootLayout.Signed.(in_toto.Layout), time.Now(), expiryWarning); err != nil {
+		return "", err
+	}
 
 	verificationDir, err := ioutil.TempDir(os.TempDir(), "in-toto")
 	if err != nil {
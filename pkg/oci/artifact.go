@@ -0,0 +1,111 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	containerdRemotes "github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// PushArtifact pushes content as the single layer of an OCI artifact, tagged as ref.
+// It returns the descriptor of the artifact manifest.
+func PushArtifact(ctx context.Context, ref, configMediaType, layerMediaType string, layer []byte) (ocispec.Descriptor, error) {
+	n, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	pusher, err := createResolver(nil).Pusher(ctx, n.String())
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("cannot create pusher for %v: %v", ref, err)
+	}
+
+	config := []byte("{}")
+	configDesc := descriptor(configMediaType, config)
+	layerDesc := descriptor(layerMediaType, layer)
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	manifestDesc := descriptor(ocispec.MediaTypeImageManifest, manifest)
+
+	for _, b := range []struct {
+		desc    ocispec.Descriptor
+		content []byte
+	}{
+		{configDesc, config},
+		{layerDesc, layer},
+		{manifestDesc, manifest},
+	} {
+		if err := pushBlob(ctx, pusher, b.desc, b.content); err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("cannot push %v to %v: %v", b.desc.MediaType, ref, err)
+		}
+	}
+	return manifestDesc, nil
+}
+
+// FetchArtifact fetches the single layer of the OCI artifact at ref.
+// Content is verified against the digests of the manifest, which is itself
+// verified when ref is a canonical (digested) reference.
+func FetchArtifact(ctx context.Context, ref string) ([]byte, error) {
+	n, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, err
+	}
+	resolver := createResolver(nil)
+	name, desc, err := resolver.Resolve(ctx, n.String())
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %v: %v", ref, err)
+	}
+	if d, ok := n.(reference.Digested); ok && d.Digest() != desc.Digest {
+		return nil, fmt.Errorf("registry returned digest %v for %v", desc.Digest, ref)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("cannot decode artifact manifest: %v", err)
+	}
+	if len(manifest.Layers) != 1 {
+		return nil, fmt.Errorf("artifact %v must have exactly one layer, found %v", ref, len(manifest.Layers))
+	}
+	return fetchBlob(ctx, fetcher, manifest.Layers[0])
+}
+
+func descriptor(mediaType string, b []byte) ocispec.Descriptor {
+	return ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+}
+
+func pushBlob(ctx context.Context, pusher containerdRemotes.Pusher, desc ocispec.Descriptor, b []byte) error {
+	w, err := pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	defer w.Close()
+	return content.Copy(ctx, w, bytes.NewReader(b), desc.Size, desc.Digest)
+}
@@ -0,0 +1,153 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cnabio/signy/pkg/canonicaljson"
+)
+
+// Kinds of signed image digests
+const (
+	// ManifestDigest is the digest of the image manifest, or image index, as served by registries
+	ManifestDigest = "manifest"
+	// ConfigDigest is the digest of the image configuration, which is the image ID. It is signed for
+	// docker save archives, since they do not contain the image manifest.
+	ConfigDigest = "config"
+)
+
+// ImageMetadata describes a signed image digest. It is stored under the "image" key of the custom TUF metadata.
+type ImageMetadata struct {
+	MediaType string `json:"mediaType"`
+	// Kind is ManifestDigest or ConfigDigest
+	Kind string `json:"kind"`
+	// Manifests are the platform manifests listed by a signed image index
+	Manifests []ocispec.Descriptor `json:"manifests,omitempty"`
+}
+
+type imageCustom struct {
+	Image *ImageMetadata `json:"image,omitempty"`
+}
+
+// ImageCustom returns the custom TUF metadata for an image, merged with the other custom metadata, such as the in-toto metadata.
+func ImageCustom(m ImageMetadata, custom canonicaljson.RawMessage) (canonicaljson.RawMessage, error) {
+	b, err := canonicaljson.Marshal(imageCustom{Image: &m})
+	if err != nil {
+		return nil, err
+	}
+	return canonicaljson.Merge(custom, b)
+}
+
+// LoadImageMetadata returns the image metadata from custom TUF metadata. Images signed without
+// image metadata are assumed to be signed by the digest of their manifest.
+func LoadImageMetadata(custom []byte) (*ImageMetadata, error) {
+	c := imageCustom{}
+	if len(custom) > 0 {
+		if err := json.Unmarshal(custom, &c); err != nil {
+			return nil, fmt.Errorf("cannot decode image metadata: %v", err)
+		}
+	}
+	if c.Image == nil {
+		return &ImageMetadata{Kind: ManifestDigest}, nil
+	}
+	if c.Image.Kind != ManifestDigest && c.Image.Kind != ConfigDigest {
+		return nil, fmt.Errorf("unknown kind of image digest %q", c.Image.Kind)
+	}
+	return c.Image, nil
+}
+
+// ResolveImageDigest returns the digest of the given kind for an image in a registry, and the descriptor
+// of the manifest it was resolved from.
+func ResolveImageDigest(ctx context.Context, ref, kind string) (ocispec.Descriptor, digest.Digest, error) {
+	desc, err := Resolve(ctx, ref)
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	if kind == ManifestDigest {
+		return desc, desc.Digest, nil
+	}
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+	default:
+		return ocispec.Descriptor{}, "", fmt.Errorf("cannot get the configuration digest of %v, with media type %v", ref, desc.MediaType)
+	}
+	n, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	fetcher, err := createResolver(nil).Fetcher(ctx, n.String())
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	b, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, "", err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return ocispec.Descriptor{}, "", fmt.Errorf("cannot decode manifest of %v: %v", ref, err)
+	}
+	return desc, manifest.Config.Digest, nil
+}
+
+// IsIndex returns whether a media type is the one of an image index, or of a Docker manifest list.
+func IsIndex(mediaType string) bool {
+	return mediaType == ocispec.MediaTypeImageIndex || mediaType == images.MediaTypeDockerSchema2ManifestList
+}
+
+// FetchIndexManifests fetches an image index from a registry, and returns the platform manifests it lists.
+func FetchIndexManifests(ctx context.Context, ref string, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	n, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := createResolver(nil).Fetcher(ctx, n.String())
+	if err != nil {
+		return nil, err
+	}
+	b, err := fetchBlob(ctx, fetcher, desc)
+	if err != nil {
+		return nil, err
+	}
+	return indexManifests(b)
+}
+
+// indexManifests returns the platform manifests listed by an image index.
+func indexManifests(b []byte) ([]ocispec.Descriptor, error) {
+	var index ocispec.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("cannot decode image index: %v", err)
+	}
+	var manifests []ocispec.Descriptor
+	for _, m := range index.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		manifests = append(manifests, ocispec.Descriptor{MediaType: m.MediaType, Digest: m.Digest, Size: m.Size, Platform: m.Platform})
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("image index lists no platform manifest")
+	}
+	return manifests, nil
+}
+
+// PlatformManifests returns the signed platform manifests for an operating system and architecture.
+func (m *ImageMetadata) PlatformManifests(os, arch string) []ocispec.Descriptor {
+	want := platforms.Normalize(ocispec.Platform{OS: os, Architecture: arch})
+	var manifests []ocispec.Descriptor
+	for _, d := range m.Manifests {
+		p := platforms.Normalize(*d.Platform)
+		if p.OS == want.OS && p.Architecture == want.Architecture {
+			manifests = append(manifests, d)
+		}
+	}
+	return manifests
+}
@@ -0,0 +1,214 @@
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	ociLayoutFile         = "oci-layout"
+	ociIndexFile          = "index.json"
+	dockerManifestFile    = "manifest.json"
+	dockerConfigMediaType = "application/vnd.docker.container.image.v1+json"
+)
+
+// LocalImage is an image read from an OCI image layout or a docker save archive
+type LocalImage struct {
+	// Descriptor is the descriptor of the signed content: the manifest, or image index,
+	// of OCI image layouts, and the image configuration of docker save archives.
+	Descriptor ocispec.Descriptor
+	// Kind is ManifestDigest or ConfigDigest
+	Kind string
+	// Manifests are the platform manifests listed by the image index, if the signed content is an image index
+	Manifests []ocispec.Descriptor
+}
+
+// dockerManifest is an entry of the manifest.json file of docker save archives
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// LoadLocalImage reads the image from an OCI image layout directory, an OCI image layout tarball,
+// or a docker save tarball (optionally gzipped). When the layout or archive contains several images,
+// refName selects the image by its reference name annotation, or its repository tag.
+func LoadLocalImage(p, refName string) (*LocalImage, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	readFile := func(name string) ([]byte, error) {
+		return readTarFile(p, name)
+	}
+	if fi.IsDir() {
+		readFile = func(name string) ([]byte, error) {
+			return ioutil.ReadFile(filepath.Join(p, filepath.FromSlash(name)))
+		}
+	}
+
+	if _, err := readFile(ociLayoutFile); err == nil {
+		return loadLayoutImage(readFile, refName)
+	}
+	if _, err := readFile(dockerManifestFile); err == nil {
+		return loadDockerArchiveImage(readFile, refName)
+	}
+	return nil, fmt.Errorf("%v is neither an OCI image layout nor a docker save archive", p)
+}
+
+func loadLayoutImage(readFile func(string) ([]byte, error), refName string) (*LocalImage, error) {
+	b, err := readFile(ociIndexFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read OCI image layout index: %v", err)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("cannot decode OCI image layout index: %v", err)
+	}
+
+	var candidates []ocispec.Descriptor
+	for _, m := range index.Manifests {
+		if refName == "" || m.Annotations[ocispec.AnnotationRefName] == refName || matchesRepoTag(m.Annotations["io.containerd.image.name"], refName) {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) != 1 {
+		return nil, fmt.Errorf("found %v images matching %q in the OCI image layout, expected exactly one", len(candidates), refName)
+	}
+
+	desc := candidates[0]
+	b, err = readBlob(readFile, desc)
+	if err != nil {
+		return nil, err
+	}
+	// Annotations and platforms are properties of the index, not of the signed content.
+	img := &LocalImage{
+		Descriptor: ocispec.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size},
+		Kind:       ManifestDigest,
+	}
+	if IsIndex(desc.MediaType) {
+		if img.Manifests, err = indexManifests(b); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+func loadDockerArchiveImage(readFile func(string) ([]byte, error), refName string) (*LocalImage, error) {
+	b, err := readFile(dockerManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []dockerManifest
+	if err := json.Unmarshal(b, &manifests); err != nil {
+		return nil, fmt.Errorf("cannot decode docker save archive manifest: %v", err)
+	}
+
+	var candidates []dockerManifest
+	for _, m := range manifests {
+		if refName == "" {
+			candidates = append(candidates, m)
+			continue
+		}
+		for _, t := range m.RepoTags {
+			if matchesRepoTag(t, refName) {
+				candidates = append(candidates, m)
+				break
+			}
+		}
+	}
+	if len(candidates) != 1 {
+		return nil, fmt.Errorf("found %v images matching %q in the docker save archive, expected exactly one", len(candidates), refName)
+	}
+
+	config, err := readFile(candidates[0].Config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image configuration: %v", err)
+	}
+	return &LocalImage{
+		Descriptor: descriptor(dockerConfigMediaType, config),
+		Kind:       ConfigDigest,
+	}, nil
+}
+
+// matchesRepoTag returns whether two image references are the same once normalized.
+func matchesRepoTag(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	na, err := reference.ParseNormalizedNamed(a)
+	if err != nil {
+		return false
+	}
+	nb, err := reference.ParseNormalizedNamed(b)
+	if err != nil {
+		return false
+	}
+	return reference.TagNameOnly(na).String() == reference.TagNameOnly(nb).String()
+}
+
+// readBlob reads the blob of a descriptor from the layout, and verifies it against the descriptor.
+func readBlob(readFile func(string) ([]byte, error), desc ocispec.Descriptor) ([]byte, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, err
+	}
+	b, err := readFile(path.Join("blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read blob %v: %v", desc.Digest, err)
+	}
+	if digest.FromBytes(b) != desc.Digest || int64(len(b)) != desc.Size {
+		return nil, fmt.Errorf("content of blob %v does not match its descriptor", desc.Digest)
+	}
+	return b, nil
+}
+
+// readTarFile reads a file from a tarball, which can be gzipped.
+func readTarFile(p, name string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%v not found in %v", name, p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %v: %v", p, err)
+		}
+		if strings.TrimPrefix(path.Clean(hdr.Name), "./") != name || !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if hdr.Size > maxBlobSize {
+			return nil, fmt.Errorf("%v is too large: %v bytes", name, hdr.Size)
+		}
+		return ioutil.ReadAll(tr)
+	}
+}
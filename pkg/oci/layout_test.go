@@ -0,0 +1,161 @@
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string][]byte) {
+	for name, b := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, ioutil.WriteFile(p, b, 0644))
+	}
+}
+
+func writeTestTar(t *testing.T, p string, gzipped bool, files map[string][]byte) {
+	f, err := os.Create(p)
+	assert.NoError(t, err)
+	defer f.Close()
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if gzipped {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for name, b := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(b)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	if gz != nil {
+		assert.NoError(t, gz.Close())
+	}
+}
+
+func TestLoadLocalImage(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "oci-layout")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	manifest := []byte(`{"schemaVersion":2,"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2}}`)
+	other := []byte(`{"schemaVersion":2}`)
+	manifestDesc := descriptor(ocispec.MediaTypeImageManifest, manifest)
+	otherDesc := descriptor(ocispec.MediaTypeImageManifest, other)
+	manifestDesc.Annotations = map[string]string{ocispec.AnnotationRefName: "v1"}
+	otherDesc.Annotations = map[string]string{ocispec.AnnotationRefName: "v2"}
+	index, err := json.Marshal(ocispec.Index{Manifests: []ocispec.Descriptor{manifestDesc, otherDesc}})
+	is.NoError(err)
+	layout := map[string][]byte{
+		"oci-layout": []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		"index.json": index,
+		"blobs/sha256/" + manifestDesc.Digest.Encoded(): manifest,
+		"blobs/sha256/" + otherDesc.Digest.Encoded():    other,
+	}
+
+	layoutDir := filepath.Join(dir, "layout")
+	writeTestFiles(t, layoutDir, layout)
+	layoutTar := filepath.Join(dir, "layout.tar.gz")
+	writeTestTar(t, layoutTar, true, layout)
+
+	for _, p := range []string{layoutDir, layoutTar} {
+		img, err := LoadLocalImage(p, "v1")
+		is.NoError(err)
+		is.Equal(ManifestDigest, img.Kind)
+		is.Equal(manifestDesc.Digest, img.Descriptor.Digest)
+		is.Nil(img.Descriptor.Annotations)
+
+		// the image must be selected when the layout contains several images.
+		_, err = LoadLocalImage(p, "")
+		is.Error(err)
+	}
+
+	// the manifest must be in the layout.
+	is.NoError(os.Remove(filepath.Join(layoutDir, "blobs", "sha256", otherDesc.Digest.Encoded())))
+	_, err = LoadLocalImage(layoutDir, "v2")
+	is.Error(err)
+
+	config := []byte(`{"architecture":"amd64"}`)
+	configFile := digest.FromBytes(config).Encoded() + ".json"
+	archive := filepath.Join(dir, "image.tar")
+	writeTestTar(t, archive, false, map[string][]byte{
+		"manifest.json": []byte(`[{"Config":"` + configFile + `","RepoTags":["localhost:5000/image:v1"],"Layers":[]}]`),
+		configFile:      config,
+	})
+	img, err := LoadLocalImage(archive, "localhost:5000/image:v1")
+	is.NoError(err)
+	is.Equal(ConfigDigest, img.Kind)
+	is.Equal(digest.FromBytes(config), img.Descriptor.Digest)
+	_, err = LoadLocalImage(archive, "localhost:5000/image:v2")
+	is.Error(err)
+}
+
+func TestImageCustom(t *testing.T) {
+	is := assert.New(t)
+
+	m, err := LoadImageMetadata(nil)
+	is.NoError(err)
+	is.Equal(ManifestDigest, m.Kind)
+
+	custom, err := ImageCustom(ImageMetadata{MediaType: dockerConfigMediaType, Kind: ConfigDigest}, []byte(`{"layout":"bGF5b3V0"}`))
+	is.NoError(err)
+	is.Equal(`{"image":{"kind":"config","mediaType":"application/vnd.docker.container.image.v1+json"},"layout":"bGF5b3V0"}`, string(custom))
+	m, err = LoadImageMetadata(custom)
+	is.NoError(err)
+	is.Equal(ConfigDigest, m.Kind)
+
+	_, err = ImageCustom(ImageMetadata{Kind: ManifestDigest}, custom)
+	is.Error(err)
+}
+
+func TestLoadLocalImageIndex(t *testing.T) {
+	is := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "oci-layout")
+	is.NoError(err)
+	defer os.RemoveAll(dir)
+
+	amd64 := descriptor(ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2,"architecture":"amd64"}`))
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := descriptor(ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2,"architecture":"arm64"}`))
+	arm64.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	attestation := descriptor(ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2}`))
+	imageIndex, err := json.Marshal(ocispec.Index{Manifests: []ocispec.Descriptor{amd64, arm64, attestation}})
+	is.NoError(err)
+	indexDesc := descriptor(ocispec.MediaTypeImageIndex, imageIndex)
+	index, err := json.Marshal(ocispec.Index{Manifests: []ocispec.Descriptor{indexDesc}})
+	is.NoError(err)
+	writeTestFiles(t, dir, map[string][]byte{
+		"oci-layout": []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		"index.json": index,
+		"blobs/sha256/" + indexDesc.Digest.Encoded(): imageIndex,
+	})
+
+	img, err := LoadLocalImage(dir, "")
+	is.NoError(err)
+	is.Equal(indexDesc.Digest, img.Descriptor.Digest)
+	// manifests without a platform, such as attestations, are not platform manifests.
+	is.Equal([]ocispec.Descriptor{amd64, arm64}, img.Manifests)
+
+	m := ImageMetadata{MediaType: ocispec.MediaTypeImageIndex, Kind: ManifestDigest, Manifests: img.Manifests}
+	custom, err := ImageCustom(m, nil)
+	is.NoError(err)
+	loaded, err := LoadImageMetadata(custom)
+	is.NoError(err)
+	is.Equal([]ocispec.Descriptor{arm64}, loaded.PlatformManifests("linux", "aarch64"))
+	is.Empty(loaded.PlatformManifests("windows", "amd64"))
+}
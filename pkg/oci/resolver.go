@@ -0,0 +1,67 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	containerdRemotes "github.com/containerd/containerd/remotes"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cnab-to-oci/remotes"
+	"github.com/docker/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxBlobSize limits the size of the manifests and metadata blobs read in memory.
+const maxBlobSize = 64 << 20
+
+func createResolver(insecureRegistries []string) containerdRemotes.Resolver {
+	return remotes.CreateResolver(config.LoadDefaultConfigFile(os.Stderr), insecureRegistries...)
+}
+
+// Resolve resolves ref to the descriptor of its manifest, or image index, in the registry.
+// The digest of a canonical (digested) reference is checked against the registry.
+func Resolve(ctx context.Context, ref string) (ocispec.Descriptor, error) {
+	n, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	_, desc, err := createResolver(nil).Resolve(ctx, n.String())
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("cannot resolve %v: %v", ref, err)
+	}
+	if d, ok := n.(reference.Digested); ok && d.Digest() != desc.Digest {
+		return ocispec.Descriptor{}, fmt.Errorf("registry returned digest %v for %v", desc.Digest, ref)
+	}
+	return desc, nil
+}
+
+// fetchBlob fetches the content of a descriptor and verifies it against the descriptor digest.
+func fetchBlob(ctx context.Context, fetcher containerdRemotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	if desc.Size > maxBlobSize {
+		return nil, fmt.Errorf("blob %v is too large: %v bytes", desc.Digest, desc.Size)
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch blob %v: %v", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(rc, maxBlobSize))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read blob %v: %v", desc.Digest, err)
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, err
+	}
+	verifier := desc.Digest.Verifier()
+	if _, err := verifier.Write(b); err != nil {
+		return nil, err
+	}
+	if !verifier.Verified() || int64(len(b)) != desc.Size {
+		return nil, fmt.Errorf("content of blob %v does not match its descriptor", desc.Digest)
+	}
+	return b, nil
+}
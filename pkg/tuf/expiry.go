@@ -0,0 +1,63 @@
+package tuf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+// RoleExpiry is the expiry date of the metadata of a TUF role
+type RoleExpiry struct {
+	Role    data.RoleName
+	Expires time.Time
+}
+
+// GetRoleExpiries returns the expiry dates of the TUF roles of the trusted collection of a reference,
+// as cached in the trust directory. The cache is only up to date after the collection has been
+// updated from the trust server, for example by listing its targets.
+func GetRoleExpiries(ref, trustDir string) ([]RoleExpiry, error) {
+	repoInfo, _, err := getRepoAndTag(ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get repo and tag from reference: %v", err)
+	}
+	metadataDir := filepath.Join(trustDir, "tuf", filepath.FromSlash(repoInfo.Name.Name()), "metadata")
+
+	var expiries []RoleExpiry
+	err = filepath.Walk(metadataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		rel, err := filepath.Rel(metadataDir, path)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var signed struct {
+			Signed data.SignedCommon `json:"signed"`
+		}
+		if err := json.Unmarshal(b, &signed); err != nil {
+			return fmt.Errorf("cannot decode TUF metadata %v: %v", path, err)
+		}
+		expiries = append(expiries, RoleExpiry{
+			Role:    data.RoleName(strings.TrimSuffix(filepath.ToSlash(rel), ".json")),
+			Expires: signed.Signed.Expires,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read TUF metadata for %v: %v", ref, err)
+	}
+	return expiries, nil
+}
@@ -0,0 +1,32 @@
+package tuf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theupdateframework/notary/tuf/data"
+)
+
+func TestGetRoleExpiries(t *testing.T) {
+	is := assert.New(t)
+
+	trustDir, err := ioutil.TempDir("", "signy-trust")
+	is.NoError(err)
+	defer os.RemoveAll(trustDir)
+
+	metadataDir := filepath.Join(trustDir, "tuf", "localhost:5000", "thin-bundle", "metadata")
+	is.NoError(os.MkdirAll(filepath.Join(metadataDir, "targets"), 0700))
+	is.NoError(ioutil.WriteFile(filepath.Join(metadataDir, "root.json"), []byte(`{"signed":{"_type":"Root","expires":"2030-01-01T00:00:00Z","version":1}}`), 0600))
+	is.NoError(ioutil.WriteFile(filepath.Join(metadataDir, "targets", "releases.json"), []byte(`{"signed":{"_type":"Targets","expires":"2021-01-01T00:00:00Z","version":2}}`), 0600))
+
+	expiries, err := GetRoleExpiries("localhost:5000/thin-bundle:v1", trustDir)
+	is.NoError(err)
+	is.Equal([]RoleExpiry{
+		{Role: data.CanonicalRootRole, Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Role: data.RoleName("targets/releases"), Expires: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}, expiries)
+}
@@ -1,11 +1,14 @@
 package tuf
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"strings"
 
 	"github.com/docker/docker/api/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/theupdateframework/notary"
 	"github.com/theupdateframework/notary/client"
 	"github.com/theupdateframework/notary/trustpinning"
@@ -179,6 +182,110 @@ func SignAndPublishWithImagePushResult(trustDir, trustServer, ref string, pushRe
 	return target, err
 }
 
+// SignAndPublishBytes signs the content of an artifact, such as a canonical bundle pulled from a registry,
+// then publishes the metadata to a trust server
+func SignAndPublishBytes(trustDir, trustServer, ref string, content []byte, tlscacert, rootKey, timeout string, custom *canonicaljson.RawMessage) (*client.Target, error) {
+	return signAndPublish(trustDir, trustServer, ref, tlscacert, rootKey, timeout, func(tag string) (*client.Target, error) {
+		return NewTargetFromBytes(tag, content, custom)
+	})
+}
+
+// SignAndPublishWithDescriptor signs the content of an OCI descriptor, such as an image manifest or image index,
+// then publishes the metadata to a trust server
+func SignAndPublishWithDescriptor(trustDir, trustServer, ref string, desc ocispec.Descriptor, tlscacert, rootKey, timeout string, custom *canonicaljson.RawMessage) (*client.Target, error) {
+	return signAndPublish(trustDir, trustServer, ref, tlscacert, rootKey, timeout, func(tag string) (*client.Target, error) {
+		return NewTargetFromDescriptor(tag, desc, custom)
+	})
+}
+
+// signAndPublish adds the target returned by newTarget for the tag of ref, initializing the repository if needed,
+// then publishes the metadata to a trust server
+func signAndPublish(trustDir, trustServer, ref, tlscacert, rootKey, timeout string, newTarget func(tag string) (*client.Target, error)) (*client.Target, error) {
+	if err := EnsureTrustDir(trustDir); err != nil {
+		return nil, fmt.Errorf("cannot ensure trust directory: %v", err)
+	}
+
+	repoInfo, tag, err := getRepoAndTag(ref)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get repo and tag from reference: %v", err)
+	}
+
+	transport, err := makeTransport(trustServer, repoInfo.Name.Name(), tlscacert, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make transport: %v", err)
+	}
+
+	repo, err := client.NewFileCachedRepository(
+		trustDir,
+		data.GUN(repoInfo.Name.Name()),
+		trustServer,
+		transport,
+		getPassphraseRetriever(),
+		trustpinning.TrustPinConfig{},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create new file cached repository: %v", err)
+	}
+
+	err = clearChangeList(repo)
+	if err != nil {
+		return nil, fmt.Errorf("cannot clear change list: %v", err)
+	}
+
+	defer clearChangeList(repo)
+
+	if _, err = repo.ListTargets(); err != nil {
+		switch err.(type) {
+		case client.ErrRepoNotInitialized, client.ErrRepositoryNotExist:
+			// Reuse root key.
+			rootKeyIDs, err := importRootKey(rootKey, repo, getPassphraseRetriever())
+			if err != nil {
+				return nil, err
+			}
+
+			// NOTE: 2nd variadic argument is to indicate that snapshot is managed remotely.
+			// The impact of a timestamp + snapshot key compromise is not terrible:
+			// https://docs.docker.com/notary/service_architecture/#threat-model
+			if err = repo.Initialize(rootKeyIDs, data.CanonicalSnapshotRole); err != nil {
+				return nil, fmt.Errorf("cannot initialize repo: %v", err)
+			}
+
+			// Reuse targets key.
+			if err = reuseTargetsKey(repo); err != nil {
+				return nil, fmt.Errorf("cannot reuse targets keys: %v", err)
+			}
+
+		default:
+			return nil, fmt.Errorf("cannot list targets: %v", err)
+		}
+	}
+
+	target, err := newTarget(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO - Radu M
+	// decide whether to allow actually passing roles as flags
+
+	// If roles is empty, we default to adding to targets
+	if err = repo.AddTarget(target, data.NewRoleList([]string{})...); err != nil {
+		return nil, err
+	}
+
+	err = repo.Publish()
+	return target, err
+}
+
+// NewTargetFromBytes returns the target of the content of an artifact
+func NewTargetFromBytes(targetName string, content []byte, targetCustom *canonicaljson.RawMessage) (*client.Target, error) {
+	meta, err := data.NewFileMeta(bytes.NewReader(content), data.NotaryDefaultHashes...)
+	if err != nil {
+		return nil, err
+	}
+	return &client.Target{Name: targetName, Hashes: meta.Hashes, Length: meta.Length, Custom: targetCustom}, nil
+}
+
 func NewTargetFromPushResult(targetName string, pushResult types.PushResult, targetCustom *canonicaljson.RawMessage) (*client.Target, error) {
 
 	meta := pushResult.Digest
@@ -194,3 +301,19 @@ func NewTargetFromPushResult(targetName string, pushResult types.PushResult, tar
 
 	return &client.Target{Name: targetName, Hashes: hashes, Length: size, Custom: targetCustom}, nil
 }
+
+// NewTargetFromDescriptor returns the target of the content of an OCI descriptor. For multi-platform images,
+// this is the digest of the image index.
+func NewTargetFromDescriptor(targetName string, desc ocispec.Descriptor, targetCustom *canonicaljson.RawMessage) (*client.Target, error) {
+	if desc.Digest.Algorithm() != digest.SHA256 {
+		return nil, fmt.Errorf("unsupported digest %v: only %v digests can be signed", desc.Digest, digest.SHA256)
+	}
+	sha, err := hex.DecodeString(desc.Digest.Encoded())
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := data.Hashes{notary.SHA256: sha}
+
+	return &client.Target{Name: targetName, Hashes: hashes, Length: desc.Size, Custom: targetCustom}, nil
+}
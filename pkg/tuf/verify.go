@@ -20,7 +20,7 @@ func GetThickBundle(localFile string) ([]byte, error) {
 
 func GetThinBundle(ref string) ([]byte, error) {
 	log.Infof("Pulling thin bundle from registry: %v", ref)
-	bun, err := cnab.Pull(ref)
+	bun, _, err := cnab.Pull(ref)
 	if err != nil {
 		return nil, err
 	}